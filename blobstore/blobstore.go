@@ -2,12 +2,14 @@ package blobstore
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,17 +18,104 @@ import (
 
 var onceBS sync.Once
 var blobStore BlobStoreClient
+var blobStoreErr error
+
+var selectedMu sync.RWMutex
+var selected BlobStoreClient
+
+// SetBlobStore selects the blob store returned by GetBlobStore, and so used
+// by flows without a blob store option of their own. Passing nil restores
+// the default, the blob service of the flow service at COMPLETER_BASE_URL.
+func SetBlobStore(c BlobStoreClient) {
+	selectedMu.Lock()
+	defer selectedMu.Unlock()
+	selected = c
+}
+
+// SelectedBlobStore returns the blob store selected with SetBlobStore, or
+// nil if none is
+func SelectedBlobStore() BlobStoreClient {
+	selectedMu.RLock()
+	defer selectedMu.RUnlock()
+	return selected
+}
+
+// GetBlobStore returns the blob store selected with SetBlobStore, or else
+// the blob service of the flow service at COMPLETER_BASE_URL
+func GetBlobStore() (BlobStoreClient, error) {
+	if c := SelectedBlobStore(); c != nil {
+		return c, nil
+	}
 
-func GetBlobStore() BlobStoreClient {
 	onceBS.Do(func() {
 		var completerURL string
 		var ok bool
 		if completerURL, ok = os.LookupEnv("COMPLETER_BASE_URL"); !ok {
-			log.Fatal("Missing COMPLETER_BASE_URL configuration in environment!")
+			blobStoreErr = errors.New("Missing COMPLETER_BASE_URL configuration in environment!")
+			return
 		}
 		blobStore = newHTTPBlobStoreClient(fmt.Sprintf("%s/blobs", completerURL))
 	})
-	return blobStore
+	return blobStore, blobStoreErr
+}
+
+// Open returns the blob store at a location, which is one of:
+//
+//	http://host/blobs     the blob service of a flow service
+//	file:///path/to/dir   a FileBlobStore in the directory
+//	/path/to/dir          the same, for any location without a scheme
+//	memory                a new MemoryBlobStore
+func Open(location string) (BlobStoreClient, error) {
+	switch {
+	case location == "memory":
+		return NewMemoryBlobStore(), nil
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		return newHTTPBlobStoreClient(strings.TrimSuffix(location, "/")), nil
+	case strings.HasPrefix(location, "file://"):
+		u, err := url.Parse(location)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid blob store location %q: %v", location, err)
+		}
+		return NewFileBlobStore(u.Path)
+	case strings.Contains(location, "://"):
+		return nil, fmt.Errorf("Unsupported blob store location %q", location)
+	}
+	return NewFileBlobStore(location)
+}
+
+// BlobStoreError reports a failed blob store operation
+type BlobStoreError struct {
+	Op         string // "write" or "read"
+	StatusCode int    // zero if no response was received
+	Err        error
+}
+
+func (e *BlobStoreError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("Blob %s failed: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("Blob %s failed, got %d response from blobstore", e.Op, e.StatusCode)
+}
+
+func (e *BlobStoreError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the operation may succeed if attempted again,
+// i.e. the HTTP request didn't reach the blobstore or it failed
+// transiently. Local failures, e.g. of a FileBlobStore, aren't retryable.
+func (e *BlobStoreError) Retryable() bool {
+	switch {
+	case e.StatusCode == http.StatusRequestTimeout, e.StatusCode == http.StatusTooManyRequests:
+		return true
+	case e.StatusCode >= 500:
+		return true
+	case e.StatusCode != 0:
+		return false
+	}
+	// http.Client reports failed requests as url.Errors
+	var urlErr *url.Error
+	return errors.As(e.Err, &urlErr) && urlErr.Op != "parse"
 }
 
 type BlobResponse struct {
@@ -40,8 +129,8 @@ func (br *BlobResponse) BlobDatum() *models.ModelBlobDatum {
 }
 
 type BlobStoreClient interface {
-	WriteBlob(prefix string, contentType string, bytes io.Reader) *BlobResponse
-	ReadBlob(prefix string, blobID string, expectedContentType string, bodyReader func(body io.ReadCloser))
+	WriteBlob(prefix string, contentType string, bytes io.Reader) (*BlobResponse, error)
+	ReadBlob(prefix string, blobID string, expectedContentType string, bodyReader func(body io.ReadCloser)) error
 }
 
 type HTTPBlobStoreClient struct {
@@ -49,7 +138,16 @@ type HTTPBlobStoreClient struct {
 	hc      *http.Client
 }
 
-func newHTTPBlobStoreClient(urlBase string) BlobStoreClient {
+// NewHTTPBlobStoreClient returns a client of the blob service at urlBase,
+// e.g. COMPLETER_BASE_URL/blobs, using hc or else a default client
+func NewHTTPBlobStoreClient(urlBase string, hc *http.Client) *HTTPBlobStoreClient {
+	if hc == nil {
+		return newHTTPBlobStoreClient(urlBase)
+	}
+	return &HTTPBlobStoreClient{urlBase: urlBase, hc: hc}
+}
+
+func newHTTPBlobStoreClient(urlBase string) *HTTPBlobStoreClient {
 	return &HTTPBlobStoreClient{
 		urlBase: urlBase,
 		hc: &http.Client{
@@ -66,37 +164,41 @@ func newHTTPBlobStoreClient(urlBase string) BlobStoreClient {
 	}
 }
 
-func (c *HTTPBlobStoreClient) WriteBlob(prefix string, contentType string, bytes io.Reader) *BlobResponse {
+func (c *HTTPBlobStoreClient) WriteBlob(prefix string, contentType string, bytes io.Reader) (*BlobResponse, error) {
 	r, err := c.hc.Post(fmt.Sprintf("%s/%s", c.urlBase, prefix), contentType, bytes)
 	if err != nil {
-		log.Fatalf("Failed to write blob: %v", err)
+		return nil, &BlobStoreError{Op: "write", Err: err}
 	}
 	defer r.Body.Close()
 
 	if r.StatusCode != 200 {
-		log.Fatalf("Write failed, got %d response from blobstore", r.StatusCode)
+		return nil, &BlobStoreError{Op: "write", StatusCode: r.StatusCode}
 	}
 
 	res := &BlobResponse{}
 	err = json.NewDecoder(r.Body).Decode(res)
 	if err != nil {
-		log.Fatal("Failed to deserialize blob response")
+		return nil, &BlobStoreError{Op: "write", StatusCode: r.StatusCode, Err: fmt.Errorf("Failed to deserialize blob response: %v", err)}
 	}
-	return res
+	return res, nil
 }
 
-func (c *HTTPBlobStoreClient) ReadBlob(prefix string, blobID string, expectedContentType string, bodyReader func(body io.ReadCloser)) {
-	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/%s/%s", c.urlBase, prefix, blobID), nil)
+func (c *HTTPBlobStoreClient) ReadBlob(prefix string, blobID string, expectedContentType string, bodyReader func(body io.ReadCloser)) error {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/%s/%s", c.urlBase, prefix, blobID), nil)
+	if err != nil {
+		return &BlobStoreError{Op: "read", Err: err}
+	}
 	req.Header.Set("Accept", expectedContentType)
 	r, err := c.hc.Do(req)
 	if err != nil {
-		log.Fatalf("Failed to read blob: %v", err)
+		return &BlobStoreError{Op: "read", Err: err}
 	}
 	defer r.Body.Close()
 
 	if r.StatusCode != 200 {
-		log.Fatalf("Read failed, got %d response from blobstore", r.StatusCode)
+		return &BlobStoreError{Op: "read", StatusCode: r.StatusCode}
 	}
 
 	bodyReader(r.Body)
+	return nil
 }
@@ -0,0 +1,79 @@
+package blobstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPBlobStoreErrorStatus(t *testing.T) {
+	tests := []struct {
+		code      int
+		retryable bool
+	}{
+		{http.StatusBadRequest, false},
+		{http.StatusNotFound, false},
+		{http.StatusRequestTimeout, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusGatewayTimeout, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.code), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, http.StatusText(tt.code), tt.code)
+			}))
+			defer srv.Close()
+			store := NewHTTPBlobStoreClient(srv.URL, srv.Client())
+
+			_, writeErr := store.WriteBlob("flow", "application/json", strings.NewReader("{}"))
+			readErr := store.ReadBlob("flow", "blob", "application/json", func(io.ReadCloser) {
+				t.Error("read a blob from a failed response")
+			})
+
+			for op, err := range map[string]error{"write": writeErr, "read": readErr} {
+				var blobErr *BlobStoreError
+				if !errors.As(err, &blobErr) {
+					t.Fatalf("%s: got %T %v, want a BlobStoreError", op, err, err)
+				}
+				if blobErr.Op != op || blobErr.StatusCode != tt.code {
+					t.Errorf("got %s status %d, want %s status %d", blobErr.Op, blobErr.StatusCode, op, tt.code)
+				}
+				if blobErr.Retryable() != tt.retryable {
+					t.Errorf("%s: got retryable %v, want %v", op, blobErr.Retryable(), tt.retryable)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPBlobStoreUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	store := NewHTTPBlobStoreClient(srv.URL, srv.Client())
+	srv.Close()
+
+	_, err := store.WriteBlob("flow", "application/json", strings.NewReader("{}"))
+	var blobErr *BlobStoreError
+	if !errors.As(err, &blobErr) {
+		t.Fatalf("got %T %v, want a BlobStoreError", err, err)
+	}
+	if blobErr.StatusCode != 0 || !blobErr.Retryable() {
+		t.Errorf("got status %d retryable %v, want 0 and true", blobErr.StatusCode, blobErr.Retryable())
+	}
+}
+
+func TestBlobStoreErrorIs(t *testing.T) {
+	cause := errors.New("boom")
+	err := fmt.Errorf("stage 1: %w", &BlobStoreError{Op: "write", Err: cause})
+	if !errors.Is(err, cause) {
+		t.Errorf("got %v, want it to wrap %v", err, cause)
+	}
+	var blobErr *BlobStoreError
+	if !errors.As(err, &blobErr) || blobErr.Op != "write" {
+		t.Errorf("got %v, want the wrapped BlobStoreError", blobErr)
+	}
+}
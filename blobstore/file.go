@@ -0,0 +1,172 @@
+package blobstore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// metaSuffix names the file next to each blob holding its metadata
+const metaSuffix = ".meta"
+
+type fileBlobMeta struct {
+	ContentType string `json:"content_type"`
+	Length      int64  `json:"length"`
+}
+
+// FileBlobStore is a BlobStoreClient that keeps blobs as files in a local
+// directory, one directory per prefix. Each blob's contents are in a file
+// named by its ID, and its content type in a .meta file beside it. Files
+// are written atomically, so readers never see partial blobs and several
+// processes can share the directory. Failures to access the files are
+// reported as BlobStoreErrors without a status code, which aren't
+// retryable.
+type FileBlobStore struct {
+	dir string
+}
+
+// NewFileBlobStore returns a store keeping blobs under dir, which is
+// created if it doesn't exist
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create blob directory: %v", err)
+	}
+	return &FileBlobStore{dir: dir}, nil
+}
+
+// Dir returns the directory the blobs are kept in
+func (s *FileBlobStore) Dir() string {
+	return s.dir
+}
+
+// Path returns the path of the file holding a blob's contents
+func (s *FileBlobStore) Path(prefix string, blobID string) string {
+	return filepath.Join(s.dir, prefix, blobID)
+}
+
+func (s *FileBlobStore) WriteBlob(prefix string, contentType string, bytes io.Reader) (*BlobResponse, error) {
+	if err := checkPathElement(prefix); err != nil {
+		return nil, &BlobStoreError{Op: "write", StatusCode: http.StatusBadRequest, Err: err}
+	}
+	dir := filepath.Join(s.dir, prefix)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, &BlobStoreError{Op: "write", Err: err}
+	}
+	blobID, err := newFileBlobID()
+	if err != nil {
+		return nil, &BlobStoreError{Op: "write", Err: err}
+	}
+
+	// the contents are written first, but only renamed into place after
+	// the metadata, so a blob that can be read always has its metadata
+	tmp, length, err := writeTemp(dir, bytes)
+	if err != nil {
+		return nil, &BlobStoreError{Op: "write", Err: err}
+	}
+	defer os.Remove(tmp)
+
+	meta, err := json.Marshal(&fileBlobMeta{ContentType: contentType, Length: length})
+	if err != nil {
+		return nil, &BlobStoreError{Op: "write", Err: err}
+	}
+	metaTmp, _, err := writeTemp(dir, strings.NewReader(string(meta)))
+	if err != nil {
+		return nil, &BlobStoreError{Op: "write", Err: err}
+	}
+	defer os.Remove(metaTmp)
+
+	path := filepath.Join(dir, blobID)
+	if err := os.Rename(metaTmp, path+metaSuffix); err != nil {
+		return nil, &BlobStoreError{Op: "write", Err: err}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, &BlobStoreError{Op: "write", Err: err}
+	}
+	return &BlobResponse{BlobId: blobID, BlobLength: length, ContentType: contentType}, nil
+}
+
+func (s *FileBlobStore) ReadBlob(prefix string, blobID string, expectedContentType string, bodyReader func(body io.ReadCloser)) error {
+	if err := checkPathElement(prefix); err != nil {
+		return &BlobStoreError{Op: "read", StatusCode: http.StatusBadRequest, Err: err}
+	}
+	if err := checkPathElement(blobID); err != nil || strings.HasSuffix(blobID, metaSuffix) {
+		return &BlobStoreError{Op: "read", StatusCode: http.StatusNotFound}
+	}
+	f, err := os.Open(s.Path(prefix, blobID))
+	if os.IsNotExist(err) {
+		return &BlobStoreError{Op: "read", StatusCode: http.StatusNotFound}
+	}
+	if err != nil {
+		return &BlobStoreError{Op: "read", Err: err}
+	}
+	defer f.Close()
+	bodyReader(f)
+	return nil
+}
+
+// ContentType returns the content type a blob was written with
+func (s *FileBlobStore) ContentType(prefix string, blobID string) (string, error) {
+	if err := checkPathElement(prefix); err != nil {
+		return "", &BlobStoreError{Op: "read", StatusCode: http.StatusBadRequest, Err: err}
+	}
+	if err := checkPathElement(blobID); err != nil {
+		return "", &BlobStoreError{Op: "read", StatusCode: http.StatusNotFound}
+	}
+	data, err := ioutil.ReadFile(s.Path(prefix, blobID) + metaSuffix)
+	if os.IsNotExist(err) {
+		return "", &BlobStoreError{Op: "read", StatusCode: http.StatusNotFound}
+	}
+	if err != nil {
+		return "", &BlobStoreError{Op: "read", Err: err}
+	}
+	var meta fileBlobMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return "", &BlobStoreError{Op: "read", Err: fmt.Errorf("Invalid blob metadata: %v", err)}
+	}
+	return meta.ContentType, nil
+}
+
+// checkPathElement rejects prefixes and IDs that would escape the store's
+// directory
+func checkPathElement(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("Invalid blob path element %q", name)
+	}
+	return nil
+}
+
+func newFileBlobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// writeTemp writes r to a new hidden file in dir, synced to disk so it can
+// be renamed into place
+func writeTemp(dir string, r io.Reader) (string, int64, error) {
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return "", 0, err
+	}
+	n, err := io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+	return f.Name(), n, nil
+}
@@ -0,0 +1,136 @@
+package blobstore
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestFileBlobStore(t *testing.T) *FileBlobStore {
+	store, err := NewFileBlobStore(filepath.Join(t.TempDir(), "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func readFileBlob(t *testing.T, store *FileBlobStore, prefix string, blobID string) (string, error) {
+	var body []byte
+	var readErr error
+	err := store.ReadBlob(prefix, blobID, "text/plain", func(r io.ReadCloser) {
+		body, readErr = ioutil.ReadAll(r)
+	})
+	if err == nil {
+		err = readErr
+	}
+	return string(body), err
+}
+
+func TestFileBlobStoreRoundTrip(t *testing.T) {
+	store := newTestFileBlobStore(t)
+	b, err := store.WriteBlob("flow", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.BlobId == "" || b.BlobLength != 5 || b.ContentType != "text/plain" {
+		t.Errorf("got %+v, want a blob of length 5 and type text/plain", b)
+	}
+
+	body, err := readFileBlob(t, store, "flow", b.BlobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "hello" {
+		t.Errorf("got %q, want %q", body, "hello")
+	}
+	contentType, err := store.ContentType("flow", b.BlobId)
+	if err != nil || contentType != "text/plain" {
+		t.Errorf("got content type %q %v, want text/plain", contentType, err)
+	}
+	if _, err := os.Stat(store.Path("flow", b.BlobId)); err != nil {
+		t.Errorf("got no file at the blob's path: %v", err)
+	}
+
+	other, err := store.WriteBlob("flow", "text/plain", strings.NewReader("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.BlobId == b.BlobId {
+		t.Errorf("got the same ID %s for two blobs", b.BlobId)
+	}
+}
+
+func TestFileBlobStoreNotFound(t *testing.T) {
+	store := newTestFileBlobStore(t)
+	b, err := store.WriteBlob("flow", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct{ prefix, blobID string }{
+		{"flow", "missing"},
+		{"other", b.BlobId},
+		// metadata files aren't blobs
+		{"flow", b.BlobId + metaSuffix},
+	} {
+		_, err := readFileBlob(t, store, tt.prefix, tt.blobID)
+		var blobErr *BlobStoreError
+		if !errors.As(err, &blobErr) || blobErr.StatusCode != http.StatusNotFound {
+			t.Errorf("reading %s/%s: got %v, want a 404 BlobStoreError", tt.prefix, tt.blobID, err)
+		}
+	}
+	if _, err := store.ContentType("flow", "missing"); err == nil {
+		t.Error("got the content type of a missing blob")
+	}
+}
+
+func TestFileBlobStoreRejectsTraversal(t *testing.T) {
+	store := newTestFileBlobStore(t)
+	// a blob outside the store that traversal would reach
+	outside := filepath.Join(filepath.Dir(store.Dir()), "secret")
+	if err := ioutil.WriteFile(outside, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"..", ".", "", "../secret", "a/b", `a\b`, "/etc"} {
+		if _, err := store.WriteBlob(name, "text/plain", strings.NewReader("x")); !isStatus(err, http.StatusBadRequest) {
+			t.Errorf("writing with prefix %q: got %v, want a 400 BlobStoreError", name, err)
+		}
+		if _, err := readFileBlob(t, store, name, "secret"); !isStatus(err, http.StatusBadRequest) {
+			t.Errorf("reading with prefix %q: got %v, want a 400 BlobStoreError", name, err)
+		}
+		if _, err := readFileBlob(t, store, "flow", name); !isStatus(err, http.StatusNotFound) {
+			t.Errorf("reading blob %q: got %v, want a 404 BlobStoreError", name, err)
+		}
+		if _, err := store.ContentType("flow", name); !isStatus(err, http.StatusNotFound) {
+			t.Errorf("reading the content type of blob %q: got %v, want a 404 BlobStoreError", name, err)
+		}
+	}
+}
+
+func TestFileBlobStoreLocalFailureNotRetryable(t *testing.T) {
+	store := newTestFileBlobStore(t)
+	// a file where the prefix's directory should be
+	if err := ioutil.WriteFile(filepath.Join(store.Dir(), "flow"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := store.WriteBlob("flow", "text/plain", strings.NewReader("x"))
+	var blobErr *BlobStoreError
+	if !errors.As(err, &blobErr) {
+		t.Fatalf("got %T %v, want a BlobStoreError", err, err)
+	}
+	if blobErr.StatusCode != 0 || blobErr.Retryable() {
+		t.Errorf("got status %d retryable %v, want 0 and false", blobErr.StatusCode, blobErr.Retryable())
+	}
+}
+
+func isStatus(err error, code int) bool {
+	var blobErr *BlobStoreError
+	return errors.As(err, &blobErr) && blobErr.StatusCode == code && !blobErr.Retryable()
+}
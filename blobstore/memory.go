@@ -0,0 +1,63 @@
+package blobstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+type memoryBlob struct {
+	contentType string
+	data        []byte
+}
+
+// MemoryBlobStore is a BlobStoreClient that keeps blobs in memory, for use
+// in tests and by local stand-ins for the flow service
+type MemoryBlobStore struct {
+	mu     sync.RWMutex
+	nextID int
+	blobs  map[string]*memoryBlob
+}
+
+func NewMemoryBlobStore() *MemoryBlobStore {
+	return &MemoryBlobStore{blobs: make(map[string]*memoryBlob)}
+}
+
+func (s *MemoryBlobStore) WriteBlob(prefix string, contentType string, bytes io.Reader) (*BlobResponse, error) {
+	data, err := ioutil.ReadAll(bytes)
+	if err != nil {
+		return nil, &BlobStoreError{Op: "write", Err: err}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	blobID := fmt.Sprintf("blob-%d", s.nextID)
+	s.blobs[prefix+"/"+blobID] = &memoryBlob{contentType: contentType, data: data}
+	return &BlobResponse{BlobId: blobID, BlobLength: int64(len(data)), ContentType: contentType}, nil
+}
+
+func (s *MemoryBlobStore) ReadBlob(prefix string, blobID string, expectedContentType string, bodyReader func(body io.ReadCloser)) error {
+	s.mu.RLock()
+	b, ok := s.blobs[prefix+"/"+blobID]
+	s.mu.RUnlock()
+	if !ok {
+		return &BlobStoreError{Op: "read", StatusCode: http.StatusNotFound}
+	}
+	bodyReader(ioutil.NopCloser(bytes.NewReader(b.data)))
+	return nil
+}
+
+// ContentType returns the content type a blob was written with
+func (s *MemoryBlobStore) ContentType(prefix string, blobID string) (string, error) {
+	s.mu.RLock()
+	b, ok := s.blobs[prefix+"/"+blobID]
+	s.mu.RUnlock()
+	if !ok {
+		return "", &BlobStoreError{Op: "read", StatusCode: http.StatusNotFound}
+	}
+	return b.contentType, nil
+}
@@ -1,8 +1,9 @@
 package flow
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
 	"os"
@@ -15,32 +16,102 @@ import (
 	"github.com/fnproject/flow-lib-go/models"
 )
 
-type remoteFlowClient struct {
-	url       string
-	flows     *flowSvc.Client
+// FlowService is the API of the flow service used by the library, as
+// implemented by the generated client/flow_service client
+type FlowService interface {
+	CreateGraph(params *flowSvc.CreateGraphParams) (*flowSvc.CreateGraphOK, error)
+	AddStage(params *flowSvc.AddStageParams) (*flowSvc.AddStageOK, error)
+	AddValueStage(params *flowSvc.AddValueStageParams) (*flowSvc.AddValueStageOK, error)
+	AddDelay(params *flowSvc.AddDelayParams) (*flowSvc.AddDelayOK, error)
+	AddInvokeFunction(params *flowSvc.AddInvokeFunctionParams) (*flowSvc.AddInvokeFunctionOK, error)
+	AwaitStageResult(params *flowSvc.AwaitStageResultParams) (*flowSvc.AwaitStageResultOK, error)
+	CompleteStageExternally(params *flowSvc.CompleteStageExternallyParams) (*flowSvc.CompleteStageExternallyOK, error)
+	Commit(params *flowSvc.CommitParams) (*flowSvc.CommitOK, error)
+	GetGraphState(params *flowSvc.GetGraphStateParams) (*flowSvc.GetGraphStateOK, error)
+}
+
+type servicesContextKey struct{}
+
+type services struct {
+	flows     FlowService
 	blobStore blobstore.BlobStoreClient
 }
 
+// WithServices returns a context that makes WithFlow handlers served with
+// it use the given flow service and blob store, rather than those at
+// COMPLETER_BASE_URL. As there, a blob store passed with WithBlobStore or
+// selected with blobstore.SetBlobStore is used instead of the given one.
+// It is intended for running flows in tests, see the flowtest package.
+func WithServices(ctx context.Context, flows FlowService, blobStore blobstore.BlobStoreClient) context.Context {
+	return context.WithValue(ctx, servicesContextKey{}, &services{flows: flows, blobStore: blobStore})
+}
+
+// BlobStoreRecorder may be implemented by a flow service passed to
+// WithServices that reads or writes the blobs of its flows itself, e.g. to
+// invoke functions. WithFlow handlers record the blob store each of their
+// flows uses, which is resolved as described for WithServices.
+type BlobStoreRecorder interface {
+	RecordBlobStore(flowID string, blobStore blobstore.BlobStoreClient)
+}
+
+// recordBlobStore records the blob store of a flow with the flow service of
+// ctx, if it was passed to WithServices and is a BlobStoreRecorder
+func recordBlobStore(ctx context.Context, flowID string, blobStore blobstore.BlobStoreClient) {
+	if svcs, ok := ctx.Value(servicesContextKey{}).(*services); ok {
+		if r, ok := svcs.flows.(BlobStoreRecorder); ok {
+			r.RecordBlobStore(flowID, blobStore)
+		}
+	}
+}
+
+type remoteFlowClient struct {
+	flows       FlowService
+	awaits      FlowService // without a request timeout, as awaits are bounded by their context
+	blobStore   blobstore.BlobStoreClient
+	contentType string // of the codec used to encode values by default
+}
+
+// requestTimeout bounds requests to the flow service other than awaits
+var requestTimeout = 30 * time.Second
+
+// awaitMargin is how much earlier than the caller's deadline the flow
+// service is asked to give up awaiting a stage, so that it times out first
+const awaitMargin = 500 * time.Millisecond
+
 func defaultHTTPClient() *http.Client {
 	tr := &http.Transport{
 		DisableKeepAlives: true,
 	}
 
 	return &http.Client{
-		Timeout:   time.Second * 30,
+		Timeout:   requestTimeout,
 		Transport: tr,
 	}
 }
 
-func newFlowClient() flowClient {
-	var completerURL string
-	var ok bool
-	if completerURL, ok = os.LookupEnv("COMPLETER_BASE_URL"); !ok {
-		log.Fatal("Missing COMPLETER_BASE_URL configuration in environment!")
+func newFlowClient(ctx context.Context, opts *flowOptions) (*remoteFlowClient, error) {
+	// a blob store option, or one selected for the process, takes
+	// precedence over the default of the flow service
+	blobStore := opts.blobStore
+	if blobStore == nil {
+		blobStore = blobstore.SelectedBlobStore()
 	}
-	cURL, err := url.Parse(completerURL)
+
+	if svcs, ok := ctx.Value(servicesContextKey{}).(*services); ok {
+		if blobStore == nil {
+			blobStore = svcs.blobStore
+		}
+		return &remoteFlowClient{
+			flows:       svcs.flows,
+			awaits:      svcs.flows,
+			blobStore:   blobStore,
+			contentType: opts.contentType,
+		}, nil
+	}
+
+	cURL, err := CompleterURL()
 	if err != nil {
-		log.Fatal("Invalid COMPLETER_BASE_URL provided!")
+		return nil, err
 	}
 
 	flowHTTPClient := httpClient
@@ -56,57 +127,82 @@ func newFlowClient() flowClient {
 		WithHTTPClient(flowHTTPClient)
 
 	sc := client.NewHTTPClientWithConfig(nil, cfg)
+	awaitClient := client.NewHTTPClientWithConfig(nil, cfg.WithHTTPClient(streamHTTPClient()))
+
+	if blobStore == nil {
+		if blobStore, err = blobstore.GetBlobStore(); err != nil {
+			return nil, err
+		}
+	}
 
 	return &remoteFlowClient{
-		url:       completerURL,
-		flows:     sc.FlowService,
-		blobStore: blobstore.GetBlobStore(),
+		flows:       sc.FlowService,
+		awaits:      awaitClient.FlowService,
+		blobStore:   blobStore,
+		contentType: opts.contentType,
+	}, nil
+}
+
+// CompleterURL returns the URL of the flow service from COMPLETER_BASE_URL
+func CompleterURL() (*url.URL, error) {
+	completerURL, ok := os.LookupEnv("COMPLETER_BASE_URL")
+	if !ok {
+		return nil, errors.New("Missing COMPLETER_BASE_URL configuration in environment!")
 	}
+	cURL, err := url.Parse(completerURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid COMPLETER_BASE_URL provided: %v", err)
+	}
+	return cURL, nil
 }
 
 type flowClient interface {
-	createFlow(functionID string) string
-	commit(flowID string)
-	getAsync(flowID string, stageID string, rType reflect.Type) (chan interface{}, chan error)
-	emptyFuture(flowID string, loc *codeLoc) string
-	completedValue(flowID string, value interface{}, loc *codeLoc) string
-	delay(flowID string, duration time.Duration, loc *codeLoc) string
-	supply(flowID string, actionFunc interface{}, loc *codeLoc) string
-	thenApply(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) string
-	thenCompose(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) string
-	whenComplete(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) string
-	thenAccept(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) string
-	thenRun(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) string
-	acceptEither(flowID string, stageID string, altStageID string, actionFunc interface{}, loc *codeLoc) string
-	applyToEither(flowID string, stageID string, altStageID string, actionFunc interface{}, loc *codeLoc) string
-	thenAcceptBoth(flowID string, stageID string, altStageID string, actionFunc interface{}, loc *codeLoc) string
-	invokeFunction(flowID string, functionID string, arg *HTTPRequest, loc *codeLoc) string
-	allOf(flowID string, stages []string, loc *codeLoc) string
-	anyOf(flowID string, stages []string, loc *codeLoc) string
-	handle(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) string
-	exceptionally(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) string
-	exceptionallyCompose(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) string
-	thenCombine(flowID string, stageID string, altStageID string, actionFunc interface{}, loc *codeLoc) string
-	complete(flowID string, stageID string, val interface{}, loc *codeLoc) bool
-}
-
-func (c *remoteFlowClient) createFlow(functionID string) string {
+	createFlow(functionID string) (string, error)
+	commit(flowID string) error
+	getAsync(ctx context.Context, f *flowFuture, rType reflect.Type) (chan interface{}, chan error)
+	emptyFuture(flowID string, loc *codeLoc) (string, error)
+	completedValue(flowID string, value interface{}, loc *codeLoc) (string, error)
+	delay(flowID string, duration time.Duration, loc *codeLoc) (string, error)
+	supply(flowID string, actionFunc interface{}, loc *codeLoc) (string, error)
+	thenApply(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) (string, error)
+	thenCompose(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) (string, error)
+	whenComplete(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) (string, error)
+	thenAccept(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) (string, error)
+	thenRun(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) (string, error)
+	acceptEither(flowID string, stageID string, altStageID string, actionFunc interface{}, loc *codeLoc) (string, error)
+	applyToEither(flowID string, stageID string, altStageID string, actionFunc interface{}, loc *codeLoc) (string, error)
+	thenAcceptBoth(flowID string, stageID string, altStageID string, actionFunc interface{}, loc *codeLoc) (string, error)
+	invokeFunction(flowID string, functionID string, arg *HTTPRequest, loc *codeLoc) (string, error)
+	allOf(flowID string, stages []string, loc *codeLoc) (string, error)
+	anyOf(flowID string, stages []string, loc *codeLoc) (string, error)
+	handle(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) (string, error)
+	exceptionally(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) (string, error)
+	exceptionallyCompose(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) (string, error)
+	thenCombine(flowID string, stageID string, altStageID string, actionFunc interface{}, loc *codeLoc) (string, error)
+	complete(ctx context.Context, flowID string, stageID string, val interface{}, loc *codeLoc) (bool, error)
+	addTerminationHook(flowID string, actionFunc interface{}, loc *codeLoc) error
+	state(ctx context.Context, flowID string) (*GraphState, error)
+	addSignal(flowID string, name string, loc *codeLoc) (string, error)
+}
+
+func (c *remoteFlowClient) createFlow(functionID string) (string, error) {
 	req := &models.ModelCreateGraphRequest{FunctionID: functionID}
 	p := flowSvc.NewCreateGraphParams().WithBody(req)
 
 	ok, err := c.flows.CreateGraph(p)
 	if err != nil {
-		log.Fatalf("Failed to create flow: %v", err)
+		return "", newClientError("create flow", err)
 	}
-	return ok.Payload.FlowID
+	return ok.Payload.FlowID, nil
 }
 
-func (c *remoteFlowClient) addStageWithClosure(flowID string, op models.ModelCompletionOperation, actionFunc interface{}, loc *codeLoc, deps ...string) string {
+func (c *remoteFlowClient) addStageWithClosure(flowID string, op models.ModelCompletionOperation, actionFunc interface{}, loc *codeLoc, deps ...string) (string, error) {
 	var closureDatum *models.ModelBlobDatum
-	if actionFunc == nil {
-		closureDatum = nil
-	} else {
-		closureDatum = actionToModel(actionFunc, flowID, c.blobStore)
+	if actionFunc != nil {
+		var err error
+		if closureDatum, err = actionToModel(actionFunc, flowID, c.blobStore); err != nil {
+			return "", newClientError(fmt.Sprintf("add stage %v", op), err)
+		}
 	}
 
 	req := &models.ModelAddStageRequest{
@@ -120,123 +216,140 @@ func (c *remoteFlowClient) addStageWithClosure(flowID string, op models.ModelCom
 
 	ok, err := c.flows.AddStage(p)
 	if err != nil {
-		log.Fatalf("Failed to add stage %v: %v", op, err)
+		return "", newClientError(fmt.Sprintf("add stage %v", op), err)
 	}
-	return ok.Payload.StageID
+	return ok.Payload.StageID, nil
 }
 
-func (c *remoteFlowClient) emptyFuture(flowID string, loc *codeLoc) string {
+func (c *remoteFlowClient) emptyFuture(flowID string, loc *codeLoc) (string, error) {
 	return c.addStageWithClosure(flowID, models.ModelCompletionOperationExternalCompletion, nil, loc, []string{}...)
 }
 
-func (c *remoteFlowClient) completedValue(flowID string, value interface{}, loc *codeLoc) string {
+func (c *remoteFlowClient) completedValue(flowID string, value interface{}, loc *codeLoc) (string, error) {
+	result, err := valueToModel(value, flowID, c.blobStore, c.contentType)
+	if err != nil {
+		return "", newClientError("add completed value stage", err)
+	}
 	req := &models.ModelAddCompletedValueStageRequest{
 		CodeLocation: loc.String(),
 		FlowID:       flowID,
-		Value:        valueToModel(value, flowID, c.blobStore),
+		Value:        result,
 	}
 	p := flowSvc.NewAddValueStageParams().WithFlowID(flowID).WithBody(req)
 
 	ok, err := c.flows.AddValueStage(p)
 	if err != nil {
-		log.Fatalf("Failed to add completed stage: %v", err)
+		return "", newClientError("add completed value stage", err)
 	}
-	return ok.Payload.StageID
+	return ok.Payload.StageID, nil
 }
 
-func (c *remoteFlowClient) supply(flowID string, actionFunc interface{}, loc *codeLoc) string {
+func (c *remoteFlowClient) supply(flowID string, actionFunc interface{}, loc *codeLoc) (string, error) {
 	return c.addStageWithClosure(flowID, models.ModelCompletionOperationSupply, actionFunc, loc, []string{}...)
 }
 
-func (c *remoteFlowClient) thenApply(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) string {
+func (c *remoteFlowClient) thenApply(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) (string, error) {
 	return c.addStageWithClosure(flowID, models.ModelCompletionOperationThenApply, actionFunc, loc, stageID)
 }
 
-func (c *remoteFlowClient) thenCompose(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) string {
+func (c *remoteFlowClient) thenCompose(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) (string, error) {
 	return c.addStageWithClosure(flowID, models.ModelCompletionOperationThenCompose, actionFunc, loc, stageID)
 }
 
-func (c *remoteFlowClient) whenComplete(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) string {
+func (c *remoteFlowClient) whenComplete(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) (string, error) {
 	return c.addStageWithClosure(flowID, models.ModelCompletionOperationWhenComplete, actionFunc, loc, stageID)
 }
 
-func (c *remoteFlowClient) thenAccept(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) string {
+func (c *remoteFlowClient) thenAccept(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) (string, error) {
 	return c.addStageWithClosure(flowID, models.ModelCompletionOperationThenAccept, actionFunc, loc, stageID)
 }
 
-func (c *remoteFlowClient) thenRun(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) string {
+func (c *remoteFlowClient) thenRun(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) (string, error) {
 	return c.addStageWithClosure(flowID, models.ModelCompletionOperationThenRun, actionFunc, loc, stageID)
 }
 
-func (c *remoteFlowClient) acceptEither(flowID string, stageID string, altStageID string, actionFunc interface{}, loc *codeLoc) string {
+func (c *remoteFlowClient) acceptEither(flowID string, stageID string, altStageID string, actionFunc interface{}, loc *codeLoc) (string, error) {
 	return c.addStageWithClosure(flowID, models.ModelCompletionOperationAcceptEither, actionFunc, loc, stageID, altStageID)
 }
 
-func (c *remoteFlowClient) applyToEither(flowID string, stageID string, altStageID string, actionFunc interface{}, loc *codeLoc) string {
+func (c *remoteFlowClient) applyToEither(flowID string, stageID string, altStageID string, actionFunc interface{}, loc *codeLoc) (string, error) {
 	return c.addStageWithClosure(flowID, models.ModelCompletionOperationApplyToEither, actionFunc, loc, stageID, altStageID)
 }
 
-func (c *remoteFlowClient) thenAcceptBoth(flowID string, stageID string, altStageID string, actionFunc interface{}, loc *codeLoc) string {
+func (c *remoteFlowClient) thenAcceptBoth(flowID string, stageID string, altStageID string, actionFunc interface{}, loc *codeLoc) (string, error) {
 	return c.addStageWithClosure(flowID, models.ModelCompletionOperationThenAcceptBoth, actionFunc, loc, stageID, altStageID)
 }
 
-func (c *remoteFlowClient) thenCombine(flowID string, stageID string, altStageID string, actionFunc interface{}, loc *codeLoc) string {
+func (c *remoteFlowClient) thenCombine(flowID string, stageID string, altStageID string, actionFunc interface{}, loc *codeLoc) (string, error) {
 	return c.addStageWithClosure(flowID, models.ModelCompletionOperationThenCombine, actionFunc, loc, stageID, altStageID)
 }
 
-func (c *remoteFlowClient) allOf(flowID string, stages []string, loc *codeLoc) string {
+func (c *remoteFlowClient) allOf(flowID string, stages []string, loc *codeLoc) (string, error) {
 	return c.addStageWithClosure(flowID, models.ModelCompletionOperationAllOf, nil, loc, stages...)
 }
 
-func (c *remoteFlowClient) anyOf(flowID string, stages []string, loc *codeLoc) string {
+func (c *remoteFlowClient) anyOf(flowID string, stages []string, loc *codeLoc) (string, error) {
 	return c.addStageWithClosure(flowID, models.ModelCompletionOperationAnyOf, nil, loc, stages...)
 }
 
-func (c *remoteFlowClient) handle(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) string {
+func (c *remoteFlowClient) handle(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) (string, error) {
 	return c.addStageWithClosure(flowID, models.ModelCompletionOperationHandle, actionFunc, loc, stageID)
 }
 
-func (c *remoteFlowClient) exceptionally(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) string {
+func (c *remoteFlowClient) exceptionally(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) (string, error) {
 	return c.addStageWithClosure(flowID, models.ModelCompletionOperationExceptionally, actionFunc, loc, stageID)
 }
 
-func (c *remoteFlowClient) exceptionallyCompose(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) string {
+func (c *remoteFlowClient) exceptionallyCompose(flowID string, stageID string, actionFunc interface{}, loc *codeLoc) (string, error) {
 	return c.addStageWithClosure(flowID, models.ModelCompletionOperationExceptionallyCompose, actionFunc, loc, stageID)
 }
 
-func (c *remoteFlowClient) complete(flowID string, stageID string, value interface{}, loc *codeLoc) bool {
+func (c *remoteFlowClient) addTerminationHook(flowID string, actionFunc interface{}, loc *codeLoc) error {
+	_, err := c.addStageWithClosure(flowID, models.ModelCompletionOperationTerminationHook, actionFunc, loc, []string{}...)
+	return err
+}
+
+func (c *remoteFlowClient) complete(ctx context.Context, flowID string, stageID string, value interface{}, loc *codeLoc) (bool, error) {
+	result, err := valueToModel(value, flowID, c.blobStore, c.contentType)
+	if err != nil {
+		return false, newClientError("complete stage", err)
+	}
 	req := &models.ModelCompleteStageExternallyRequest{
 		CodeLocation: loc.String(),
 		FlowID:       flowID,
 		StageID:      stageID,
-		Value:        valueToModel(value, flowID, c.blobStore),
+		Value:        result,
 	}
-	p := flowSvc.NewCompleteStageExternallyParams().WithFlowID(flowID).WithStageID(stageID).WithBody(req)
+	p := flowSvc.NewCompleteStageExternallyParamsWithContext(ctx).WithFlowID(flowID).WithStageID(stageID).WithBody(req)
 
 	ok, err := c.flows.CompleteStageExternally(p)
 	if err != nil {
-		log.Fatalf("Failed to add completed stage: %v", err)
+		return false, newClientError("complete stage", err)
 	}
-	return ok.Payload.Successful
+	return ok.Payload.Successful, nil
 }
 
-func (c *remoteFlowClient) invokeFunction(flowID string, functionID string, arg *HTTPRequest, loc *codeLoc) string {
+func (c *remoteFlowClient) invokeFunction(flowID string, functionID string, arg *HTTPRequest, loc *codeLoc) (string, error) {
+	reqDatum, err := requestToModel(arg, flowID, c.blobStore)
+	if err != nil {
+		return "", newClientError("add invoke stage", err)
+	}
 	req := &models.ModelAddInvokeFunctionStageRequest{
 		CodeLocation: loc.String(),
 		FlowID:       flowID,
 		FunctionID:   functionID,
-		Arg:          requestToModel(arg, flowID, c.blobStore),
+		Arg:          reqDatum,
 	}
 	p := flowSvc.NewAddInvokeFunctionParams().WithFlowID(flowID).WithBody(req)
 
 	ok, err := c.flows.AddInvokeFunction(p)
 	if err != nil {
-		log.Fatalf("Failed to add invoke stage: %v", err)
+		return "", newClientError("add invoke stage", err)
 	}
-	return ok.Payload.StageID
+	return ok.Payload.StageID, nil
 }
 
-func (c *remoteFlowClient) delay(flowID string, duration time.Duration, loc *codeLoc) string {
+func (c *remoteFlowClient) delay(flowID string, duration time.Duration, loc *codeLoc) (string, error) {
 	req := &models.ModelAddDelayStageRequest{
 		CodeLocation: loc.String(),
 		FlowID:       flowID,
@@ -246,42 +359,94 @@ func (c *remoteFlowClient) delay(flowID string, duration time.Duration, loc *cod
 
 	ok, err := c.flows.AddDelay(p)
 	if err != nil {
-		log.Fatalf("Failed to add delay stage: %v", err)
+		return "", newClientError("add delay stage", err)
 	}
-	return ok.Payload.StageID
+	return ok.Payload.StageID, nil
 }
 
-func (c *remoteFlowClient) getAsync(flowID string, stageID string, rType reflect.Type) (chan interface{}, chan error) {
+func (c *remoteFlowClient) getAsync(ctx context.Context, f *flowFuture, rType reflect.Type) (chan interface{}, chan error) {
 	valueCh := make(chan interface{}, 1)
 	errorCh := make(chan error, 1)
-	go c.get(flowID, stageID, rType, valueCh, errorCh)
+	go c.get(ctx, f, rType, valueCh, errorCh)
 	return valueCh, errorCh
 }
 
-func (c *remoteFlowClient) get(flowID string, stageID string, rType reflect.Type, valueCh chan interface{}, errorCh chan error) {
-	p := flowSvc.NewAwaitStageResultParams().WithFlowID(flowID).WithStageID(stageID)
-	ok, err := c.flows.AwaitStageResult(p)
+func (c *remoteFlowClient) get(ctx context.Context, f *flowFuture, rType reflect.Type, valueCh chan interface{}, errorCh chan error) {
+	p := flowSvc.NewAwaitStageResultParamsWithContext(ctx).WithFlowID(f.flowID).WithStageID(f.stageID)
+	if deadline, ok := ctx.Deadline(); ok {
+		// let the completer give up waiting before we cancel the request,
+		// so the caller gets ErrAwaitTimeout
+		timeoutMs := int32((time.Until(deadline) - awaitMargin) / time.Millisecond)
+		if timeoutMs < 0 {
+			timeoutMs = 0
+		}
+		p = p.WithTimeoutMs(&timeoutMs)
+	}
+	ok, err := c.awaits.AwaitStageResult(p)
 	if err != nil {
 		debug(fmt.Sprintf("Failed to await stage result: %v", err))
-		errorCh <- err
+		errorCh <- awaitError(ctx, err)
 		return
 	}
 
 	result := ok.Payload.Result
-	val := decodeResult(result, flowID, rType, c.blobStore)
+	val, err := decodeResult(result, f.flow, rType, c.blobStore)
+	if fe, ok := err.(*FunctionError); ok {
+		// a response with an error status decoded as a typed value
+		val, err = fe, nil
+		result.Successful = false
+	}
+	if err != nil {
+		debug(fmt.Sprintf("Failed to decode stage result: %v", err))
+		errorCh <- newClientError("decode stage result", err)
+		return
+	}
 	if result.Successful {
 		debug("Getting successful result")
 		valueCh <- val
 	} else {
 		debug("Getting failed result")
-		errorCh <- val.(error)
+		failure := val.(error)
+		if fe, ok := failure.(*FunctionError); ok && fe.FunctionID == "" {
+			fe.FunctionID = f.functionID
+		}
+		errorCh <- failure
 	}
 }
 
-func (c *remoteFlowClient) commit(flowID string) {
+func (c *remoteFlowClient) commit(flowID string) error {
 	p := flowSvc.NewCommitParams().WithFlowID(flowID)
 	_, err := c.flows.Commit(p)
 	if err != nil {
-		log.Fatalf("Failed to commit flow: %v", err)
+		return newClientError("commit flow", err)
+	}
+	return nil
+}
+
+func (c *remoteFlowClient) state(ctx context.Context, flowID string) (*GraphState, error) {
+	p := flowSvc.NewGetGraphStateParamsWithContext(ctx).WithFlowID(flowID)
+	ok, err := c.flows.GetGraphState(p)
+	if err != nil {
+		return nil, newClientError("get flow state", err)
+	}
+	return stateFromModel(ok.Payload), nil
+}
+
+// addSignal adds an externalCompletion stage waiting for the named signal,
+// whose name the flow service reports in the flow's state
+func (c *remoteFlowClient) addSignal(flowID string, name string, loc *codeLoc) (string, error) {
+	req := &models.ModelAddStageRequest{
+		CodeLocation: loc.String(),
+		Deps:         []string{},
+		FlowID:       flowID,
+		Operation:    models.ModelCompletionOperationExternalCompletion,
+		Signal:       name,
+	}
+	p := flowSvc.NewAddStageParams().WithFlowID(flowID).WithBody(req)
+
+	ok, err := c.flows.AddStage(p)
+	if err != nil {
+		return "", newClientError("add signal stage", err)
 	}
+	return ok.Payload.StageID, nil
 }
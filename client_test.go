@@ -0,0 +1,109 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fnproject/flow-lib-go/blobstore"
+)
+
+func TestNewFlowClientBlobStore(t *testing.T) {
+	t.Setenv("COMPLETER_BASE_URL", "http://completer")
+	option := blobstore.NewMemoryBlobStore()
+	selected := blobstore.NewMemoryBlobStore()
+	service := blobstore.NewMemoryBlobStore()
+	servicesCtx := WithServices(context.Background(), nil, service)
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		option   blobstore.BlobStoreClient
+		selected blobstore.BlobStoreClient
+		want     blobstore.BlobStoreClient
+	}{
+		{"option", context.Background(), option, selected, option},
+		{"selected", context.Background(), nil, selected, selected},
+		{"services option", servicesCtx, option, selected, option},
+		{"services selected", servicesCtx, nil, selected, selected},
+		{"services", servicesCtx, nil, nil, service},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blobstore.SetBlobStore(tt.selected)
+			t.Cleanup(func() { blobstore.SetBlobStore(nil) })
+
+			client, err := newFlowClient(tt.ctx, &flowOptions{contentType: GobMediaHeader, blobStore: tt.option})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if client.blobStore != tt.want {
+				t.Errorf("got blob store %p, want %p", client.blobStore, tt.want)
+			}
+		})
+	}
+}
+
+// awaitServer returns a server answering awaits of stage 1 of flow after
+// delay, with a timeout if the awaiting client asks for one sooner. The
+// timeouts asked for are sent to timeouts.
+func awaitServer(t *testing.T, delay time.Duration, timeouts chan<- int) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/flows/flow/stages/1/await" {
+			http.NotFound(w, r)
+			return
+		}
+		wait := delay
+		if ms := r.URL.Query().Get("timeout_ms"); ms != "" {
+			timeout, err := strconv.Atoi(ms)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			timeouts <- timeout
+			if d := time.Duration(timeout) * time.Millisecond; d < wait {
+				time.Sleep(d)
+				http.Error(w, "timed out", http.StatusRequestTimeout)
+				return
+			}
+		}
+		time.Sleep(wait)
+		w.Header().Set("Content-Type", JSONMediaHeader)
+		fmt.Fprint(w, `{"flow_id":"flow","stage_id":"1","result":{"successful":true,"datum":{"empty":{}}}}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func awaitFuture(t *testing.T, baseURL string) *flowFuture {
+	client := testFlowClient(t, baseURL, blobstore.NewMemoryBlobStore())
+	return &flowFuture{flow: &flow{client: client, blobStore: client.blobStore, flowID: "flow"}, stageID: "1"}
+}
+
+func TestAwaitOutlastsRequestTimeout(t *testing.T) {
+	timeout := requestTimeout
+	requestTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { requestTimeout = timeout })
+
+	timeouts := make(chan int, 1)
+	f := awaitFuture(t, awaitServer(t, 500*time.Millisecond, timeouts).URL)
+	if v, err := f.GetWithTimeout(5 * time.Second); err != nil || v != nil {
+		t.Fatalf("got %v %v, want the stage's empty result", v, err)
+	}
+	// the service is asked to time out before the caller's deadline
+	if got := <-timeouts; got <= 4000 || got >= 5000 {
+		t.Errorf("got timeout_ms %d, want it a little below 5000", got)
+	}
+}
+
+func TestAwaitServiceTimesOutFirst(t *testing.T) {
+	timeouts := make(chan int, 1)
+	f := awaitFuture(t, awaitServer(t, time.Minute, timeouts).URL)
+	if _, err := f.GetWithTimeout(time.Second); err != ErrAwaitTimeout {
+		t.Errorf("got %v, want ErrAwaitTimeout", err)
+	}
+}
@@ -0,0 +1,61 @@
+// Command completer-emulator serves a local stand-in for the Fn flow
+// service. Point functions at it with COMPLETER_BASE_URL=http://<listen addr>
+// and configure where their continuations are sent:
+//
+//	completer-emulator -listen :8081 -function-url http://localhost:8080/
+//	completer-emulator -function myapp/myfunc=http://localhost:8080/ -function myapp/other=http://localhost:8082/
+//	completer-emulator -function-url http://localhost:8080/ -blobs /tmp/flow-blobs
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/fnproject/flow-lib-go/blobstore"
+	"github.com/fnproject/flow-lib-go/emulator"
+)
+
+// functionURLs collects repeated -function id=url flags
+type functionURLs map[string]string
+
+func (f functionURLs) String() string {
+	var pairs []string
+	for id, url := range f {
+		pairs = append(pairs, id+"="+url)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f functionURLs) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("expected <function id>=<url>, got %q", value)
+	}
+	f[parts[0]] = parts[1]
+	return nil
+}
+
+func main() {
+	listen := flag.String("listen", ":8081", "address to serve the flow service and blob store on")
+	defaultURL := flag.String("function-url", "", "URL of functions without a -function URL of their own")
+	blobs := flag.String("blobs", "memory", "where to keep blobs: memory, or a directory to keep them as files in")
+	functions := make(functionURLs)
+	flag.Var(functions, "function", "URL of a function, as <function id>=<url> (repeatable)")
+	flag.Parse()
+
+	blobStore, err := blobstore.Open(*blobs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts := []emulator.Option{emulator.WithDefaultFunctionURL(*defaultURL), emulator.WithBlobStore(blobStore)}
+	for id, url := range functions {
+		opts = append(opts, emulator.WithFunctionURL(id, url))
+	}
+
+	log.Printf("Serving flow service on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, emulator.New(opts...)))
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	flow "github.com/fnproject/flow-lib-go"
+	"github.com/fnproject/flow-lib-go/blobstore"
+	flowSvc "github.com/fnproject/flow-lib-go/client/flow_service"
+	"github.com/fnproject/flow-lib-go/events"
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+func create(ctx context.Context, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("create", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	svc, err := flowService()
+	if err != nil {
+		return err
+	}
+	p := flowSvc.NewCreateGraphParamsWithContext(ctx).
+		WithBody(&models.ModelCreateGraphRequest{FunctionID: args[0]})
+	ok, err := svc.CreateGraph(p)
+	if err != nil {
+		return serviceError("create flow", err)
+	}
+	fmt.Println(ok.Payload.FlowID)
+	return nil
+}
+
+func state(ctx context.Context, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("state", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	g, err := flow.InspectContext(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Flow %s of %s\n\n", g.FlowID, g.FunctionID)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STAGE\tOPERATION\tSTATUS\tDEPENDENCIES")
+	for _, s := range g.Stages {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.ID, s.Operation, s.Status, strings.Join(s.Dependencies, ","))
+	}
+	return tw.Flush()
+}
+
+func tail(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	from := fs.Uint64("from", 0, "only show events after this sequence number")
+	args, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	evs, err := flow.WatchFlow(ctx, args[0], *from)
+	if err != nil {
+		return err
+	}
+	for m := range evs {
+		ev, err := events.FromModel(m)
+		if err != nil {
+			continue
+		}
+		fmt.Println(describe(ev))
+	}
+	return nil
+}
+
+// describe formats an event as a line of tail's output
+func describe(ev events.Event) string {
+	h := ev.EventHeader()
+	var what string
+	switch ev := ev.(type) {
+	case *events.GraphCreated:
+		what = fmt.Sprintf("flow created by %s", ev.FunctionID)
+	case *events.GraphCommitted:
+		what = "flow committed"
+	case *events.GraphTerminating:
+		what = fmt.Sprintf("flow terminating: %s", ev.Status)
+	case *events.GraphCompleted:
+		what = "flow completed"
+	case *events.StageAdded:
+		what = fmt.Sprintf("stage %s added: %s", ev.StageID, ev.Op)
+		if len(ev.Dependencies) > 0 {
+			what += fmt.Sprintf(" of %s", strings.Join(ev.Dependencies, ","))
+		}
+		if ev.CodeLocation != "" {
+			what += fmt.Sprintf(" at %s", ev.CodeLocation)
+		}
+	case *events.StageCompleted:
+		what = fmt.Sprintf("stage %s completed: %v", ev.StageID, ev.Result)
+	case *events.StageComposed:
+		what = fmt.Sprintf("stage %s composed with stage %s", ev.StageID, ev.ComposedStageID)
+	case *events.DelayScheduled:
+		what = fmt.Sprintf("stage %s delayed until %s", ev.StageID, ev.At.Format(time.RFC3339Nano))
+	case *events.FunctionStarted:
+		what = fmt.Sprintf("stage %s invoking %s", ev.StageID, ev.FunctionID)
+	case *events.FunctionCompleted:
+		what = fmt.Sprintf("stage %s invocation completed: %v", ev.StageID, ev.Result)
+	}
+	return fmt.Sprintf("%4d %s %s", h.Seq, h.Time.Format(time.RFC3339Nano), what)
+}
+
+func lifecycle(ctx context.Context, args []string) error {
+	if _, err := parseArgs(flag.NewFlagSet("lifecycle", flag.ContinueOnError), args, 0); err != nil {
+		return err
+	}
+	evs, err := flow.WatchLifecycleEvents(ctx)
+	if err != nil {
+		return err
+	}
+	for ev := range evs {
+		switch {
+		case ev.GraphCreated != nil:
+			fmt.Printf("%s %s created by %s\n", time.Time(ev.GraphCreated.Ts).Format(time.RFC3339Nano), ev.FlowID, ev.GraphCreated.FunctionID)
+		case ev.GraphCompleted != nil:
+			fmt.Printf("%s %s completed\n", time.Time(ev.GraphCompleted.Ts).Format(time.RFC3339Nano), ev.FlowID)
+		}
+	}
+	return nil
+}
+
+func complete(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("complete", flag.ContinueOnError)
+	value := fs.String("value", "", "JSON value to complete the stage with")
+	failure := fs.String("error", "", "message of an error to fail the stage with")
+	args, err := parseArgs(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	flowID, stageID := args[0], args[1]
+
+	var body bytes.Buffer
+	var successful bool
+	switch {
+	case *value != "" && *failure == "":
+		if !json.Valid([]byte(*value)) {
+			return fmt.Errorf("Invalid JSON value %s", *value)
+		}
+		body.WriteString(*value)
+		successful = true
+	case *failure != "" && *value == "":
+		// encoded as the library encodes errors, so flows can decode it
+		if err := json.NewEncoder(&body).Encode(&flow.ErrorResult{Error: *failure}); err != nil {
+			return err
+		}
+	default:
+		return errUsage
+	}
+
+	bs, err := blobstore.GetBlobStore()
+	if err != nil {
+		return err
+	}
+	b, err := bs.WriteBlob(flowID, flow.JSONMediaHeader, &body)
+	if err != nil {
+		return err
+	}
+
+	svc, err := flowService()
+	if err != nil {
+		return err
+	}
+	p := flowSvc.NewCompleteStageExternallyParamsWithContext(ctx).
+		WithFlowID(flowID).
+		WithStageID(stageID).
+		WithBody(&models.ModelCompleteStageExternallyRequest{
+			FlowID:  flowID,
+			StageID: stageID,
+			Value:   &models.ModelCompletionResult{Successful: successful, Datum: &models.ModelDatum{Blob: b.BlobDatum()}},
+		})
+	ok, err := svc.CompleteStageExternally(p)
+	if err != nil {
+		return serviceError("complete stage", err)
+	}
+	if !ok.Payload.Successful {
+		return errors.New("Stage was already complete")
+	}
+	return nil
+}
+
+func await(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("await", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", 0, "how long to wait for the stage, or forever if zero")
+	args, err := parseArgs(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	flowID, stageID := args[0], args[1]
+
+	svc, err := flowService()
+	if err != nil {
+		return err
+	}
+	p := flowSvc.NewAwaitStageResultParamsWithContext(ctx).WithFlowID(flowID).WithStageID(stageID)
+	if *timeout > 0 {
+		timeoutMs := int32(*timeout / time.Millisecond)
+		p = p.WithTimeoutMs(&timeoutMs)
+	}
+	ok, err := svc.AwaitStageResult(p)
+	if err != nil {
+		return serviceError("await stage", err)
+	}
+
+	result := events.ResultFromModel(flowID, ok.Payload.Result)
+	fmt.Println(result)
+	if blob, isBlob := result.Datum.(*events.Blob); isBlob && printable(blob.ContentType) {
+		bs, err := blobstore.GetBlobStore()
+		if err != nil {
+			return err
+		}
+		data, err := blob.Read(bs)
+		if err != nil {
+			return err
+		}
+		fmt.Println(strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+// printable reports whether blobs of the content type are text, rather
+// than e.g. gob-encoded
+func printable(contentType string) bool {
+	return contentType == flow.JSONMediaHeader || strings.HasPrefix(contentType, "text/")
+}
+
+func graph(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("graph", flag.ContinueOnError)
+	format := fs.String("format", "dot", "output format, dot or mermaid")
+	eventsFile := fs.String("events", "", "replay the graph from a saved event stream rather than fetching its state")
+	args, err := parseArgs(fs, args, -1)
+	if err != nil {
+		return err
+	}
+
+	var g *flow.GraphState
+	switch {
+	case *eventsFile != "" && len(args) == 0:
+		f, err := os.Open(*eventsFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		evs, err := events.ReadAll(f)
+		if err != nil {
+			return fmt.Errorf("Failed to read events: %v", err)
+		}
+		g = flow.ReplayState(evs)
+	case *eventsFile == "" && len(args) == 1:
+		if g, err = flow.InspectContext(ctx, args[0]); err != nil {
+			return err
+		}
+	default:
+		return errUsage
+	}
+
+	switch *format {
+	case "dot":
+		return g.WriteDOT(os.Stdout)
+	case "mermaid":
+		return g.WriteMermaid(os.Stdout)
+	}
+	return fmt.Errorf("Unknown format %q", *format)
+}
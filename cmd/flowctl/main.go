@@ -0,0 +1,126 @@
+// Command flowctl inspects and drives flows on the flow service at
+// COMPLETER_BASE_URL:
+//
+//	flowctl create <function id>
+//	flowctl state <flow id>
+//	flowctl tail [-from <seq>] <flow id>
+//	flowctl lifecycle
+//	flowctl complete <flow id> <stage id> -value <json> | -error <message>
+//	flowctl await [-timeout <duration>] <flow id> <stage id>
+//	flowctl graph [-format dot|mermaid] [-events <file>] [<flow id>]
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	flow "github.com/fnproject/flow-lib-go"
+	client "github.com/fnproject/flow-lib-go/client"
+	flowSvc "github.com/fnproject/flow-lib-go/client/flow_service"
+	"github.com/go-openapi/runtime"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"create":    {"<function id>", create},
+	"state":     {"<flow id>", state},
+	"tail":      {"[-from <seq>] <flow id>", tail},
+	"lifecycle": {"", lifecycle},
+	"complete":  {"<flow id> <stage id> -value <json> | -error <message>", complete},
+	"await":     {"[-timeout <duration>] <flow id> <stage id>", await},
+	"graph":     {"[-format dot|mermaid] [-events <file>] [<flow id>]", graph},
+}
+
+var commandOrder = []string{"create", "state", "tail", "lifecycle", "complete", "await", "graph"}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: flowctl <command> [arguments]\n\nCommands:\n")
+	for _, name := range commandOrder {
+		fmt.Fprintf(os.Stderr, "  %s\n", strings.TrimSpace(name+" "+commands[name].usage))
+	}
+	fmt.Fprintf(os.Stderr, "\nThe flow service is found at COMPLETER_BASE_URL.\n")
+}
+
+// errUsage makes main print the usage of the command that returned it
+var errUsage = errors.New("usage")
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "flowctl: unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := cmd.run(ctx, os.Args[2:])
+	if err == errUsage || err == flag.ErrHelp {
+		fmt.Fprintf(os.Stderr, "Usage: flowctl %s %s\n", os.Args[1], cmd.usage)
+		os.Exit(2)
+	}
+	if err != nil && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "flowctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseArgs parses flags wherever they appear among the arguments,
+// returning the positional arguments, of which there must be n
+func parseArgs(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	fs.SetOutput(os.Stderr)
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if n >= 0 && len(positional) != n {
+		return nil, errUsage
+	}
+	return positional, nil
+}
+
+// flowService returns a client of the flow service without a timeout, as
+// awaiting a stage may take as long as the stage does
+func flowService() (*flowSvc.Client, error) {
+	cURL, err := flow.CompleterURL()
+	if err != nil {
+		return nil, err
+	}
+	cfg := client.DefaultTransportConfig().
+		WithHost(cURL.Host).
+		WithBasePath(cURL.Path).
+		WithSchemes([]string{cURL.Scheme}).
+		WithHTTPClient(&http.Client{})
+	return client.NewHTTPClientWithConfig(nil, cfg).FlowService, nil
+}
+
+// serviceError describes a failed call of the flow service, without the
+// generated client's dump of the response
+func serviceError(op string, err error) error {
+	var apiErr *runtime.APIError
+	if errors.As(err, &apiErr) {
+		return fmt.Errorf("Failed to %s, got %d response from flow service", op, apiErr.Code)
+	}
+	return fmt.Errorf("Failed to %s: %v", op, err)
+}
@@ -2,12 +2,11 @@ package flow
 
 import (
 	"bytes"
-	"encoding/gob"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"mime"
 	"net/http"
 	"reflect"
 	"strings"
@@ -16,16 +15,29 @@ import (
 	"github.com/fnproject/flow-lib-go/models"
 )
 
-func actionToModel(actionFunc interface{}, flowID string, blobStore blobstore.BlobStoreClient) *models.ModelBlobDatum {
-	b := blobStore.WriteBlob(flowID, JSONMediaHeader, encodeAction(actionFunc))
+func actionToModel(actionFunc interface{}, flowID string, blobStore blobstore.BlobStoreClient) (*models.ModelBlobDatum, error) {
+	buf, err := encodeAction(actionFunc)
+	if err != nil {
+		return nil, err
+	}
+	b, err := blobStore.WriteBlob(flowID, JSONMediaHeader, buf)
+	if err != nil {
+		return nil, err
+	}
 	debug(fmt.Sprintf("Published blob %v", b.BlobId))
-	return &models.ModelBlobDatum{BlobID: b.BlobId, ContentType: b.ContentType, Length: b.BlobLength}
+	return &models.ModelBlobDatum{BlobID: b.BlobId, ContentType: b.ContentType, Length: b.BlobLength}, nil
 }
 
-func valueToModel(value interface{}, flowID string, blobStore blobstore.BlobStoreClient) *models.ModelCompletionResult {
+// valueToModel encodes successful values with the codec for their type, or
+// the codec for defaultType if none was selected for the type
+func valueToModel(value interface{}, flowID string, blobStore blobstore.BlobStoreClient, defaultType string) (*models.ModelCompletionResult, error) {
 	datum := new(models.ModelDatum)
 	switch v := value.(type) {
 
+	case typedFuture:
+		debug("Converting typed future to ModelStageRefDatum")
+		datum.StageRef = &models.ModelStageRefDatum{StageID: v.untyped().stageID}
+
 	case FlowFuture:
 		debug("Converting value to ModelStageRefDatum")
 		ff, ok := v.(*flowFuture)
@@ -49,30 +61,43 @@ func valueToModel(value interface{}, flowID string, blobStore blobstore.BlobStor
 			break
 		}
 
-		var body io.Reader
+		var body *bytes.Buffer
 		var contentType string
+		var err error
 		if errv, isErr := value.(error); isErr {
-			body = encodeError(errv)
+			body, err = encodeError(errv)
 			contentType = JSONMediaHeader
 		} else {
-			body = encodeGob(value)
-			contentType = GobMediaHeader
+			var c ValueCodec
+			if c, err = codecFor(value, defaultType); err == nil {
+				body, err = encodeValue(c, value)
+				contentType = c.ContentType()
+			}
+		}
+		if err != nil {
+			return nil, err
 		}
 		debug("Converting value to ModelBlobDatum")
-		b := blobStore.WriteBlob(flowID, contentType, body)
+		b, err := blobStore.WriteBlob(flowID, contentType, body)
+		if err != nil {
+			return nil, err
+		}
 		datum.Blob = &models.ModelBlobDatum{BlobID: b.BlobId, ContentType: b.ContentType, Length: b.BlobLength}
 	}
 
 	_, isErr := value.(error)
-	return &models.ModelCompletionResult{Successful: !isErr, Datum: datum}
+	return &models.ModelCompletionResult{Successful: !isErr, Datum: datum}, nil
 }
 
-func requestToModel(req *HTTPRequest, flowID string, blobStore blobstore.BlobStoreClient) *models.ModelHTTPReqDatum {
+func requestToModel(req *HTTPRequest, flowID string, blobStore blobstore.BlobStoreClient) (*models.ModelHTTPReqDatum, error) {
 	cType := req.Headers.Get(ContentTypeHeader)
 	if cType == "" {
 		cType = OctetStreamMediaHeader
 	}
-	b := blobStore.WriteBlob(flowID, cType, bytes.NewReader(req.Body))
+	b, err := blobStore.WriteBlob(flowID, cType, bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, err
+	}
 
 	var headers []*models.ModelHTTPHeader
 	for key, values := range req.Headers {
@@ -80,156 +105,209 @@ func requestToModel(req *HTTPRequest, flowID string, blobStore blobstore.BlobSto
 			headers = append(headers, &models.ModelHTTPHeader{Key: key, Value: value})
 		}
 	}
-	return &models.ModelHTTPReqDatum{Body: b.BlobDatum(), Headers: headers, Method: models.ModelHTTPMethod(strings.ToLower(req.Method))}
+	return &models.ModelHTTPReqDatum{Body: b.BlobDatum(), Headers: headers, Method: models.ModelHTTPMethod(strings.ToLower(req.Method))}, nil
 }
 
-func encodeAction(actionFunc interface{}) *bytes.Buffer {
-	cr := newActionRef(actionFunc)
+func encodeAction(actionFunc interface{}) (*bytes.Buffer, error) {
+	cr, err := newActionRef(actionFunc)
+	if err != nil {
+		return nil, err
+	}
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(cr); err != nil {
-		log.Fatalf("Failed to encode continuation reference: %v", err.Error())
+		return nil, fmt.Errorf("Failed to encode continuation reference: %v", err)
 	}
-	return &buf
+	return &buf, nil
 }
 
-func encodeGob(value interface{}) *bytes.Buffer {
+func encodeValue(c ValueCodec, value interface{}) (*bytes.Buffer, error) {
 	var buf bytes.Buffer
-	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
-		log.Fatalf("Failed to encode gob: %v", err.Error())
+	if err := c.Encode(&buf, value); err != nil {
+		return nil, fmt.Errorf("Failed to encode %v: %v", c.ContentType(), err)
 	}
-	return &buf
+	return &buf, nil
 }
 
-func encodeError(e error) *bytes.Buffer {
+func encodeError(e error) (*bytes.Buffer, error) {
 	result := &ErrorResult{Error: e.Error()}
+	errors.As(e, &result.Panic)
+	errors.As(e, &result.Platform)
+	errors.As(e, &result.Function)
+	errors.As(e, &result.Continuation)
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(result); err != nil {
-		log.Fatalf("Failed to encode error: %v", err.Error())
+		return nil, fmt.Errorf("Failed to encode error: %v", err)
 	}
-	return &buf
+	return &buf, nil
 }
 
 // converts back to Go and API types - yuck!
-func decodeResult(result *models.ModelCompletionResult, flowID string, rType reflect.Type, blobStore blobstore.BlobStoreClient) interface{} {
+// The returned error reports a failure to decode the result, whereas a
+// failed stage is returned as a value implementing error.
+func decodeResult(result *models.ModelCompletionResult, f *flow, rType reflect.Type, blobStore blobstore.BlobStoreClient) (interface{}, error) {
+	if !result.Successful {
+		return datumToError(result.Datum.InnerDatum(), f.flowID, blobStore)
+	}
 	if rType == nil {
 		debug("Returning nil since no return type info available")
-		return nil
+		return nil, nil
 	}
 	// special case since ModelEmptyDatum is an alias for the empty interface
 	if result.Datum.Empty != nil {
 		debug("Decoded nil result")
-		return nil
+		return nil, nil
 	}
 
 	datum := result.Datum.InnerDatum()
 	debug(fmt.Sprintf("Decoded datum of type %v", reflect.TypeOf(datum)))
-	if result.Successful {
-		return datumToValue(datum, flowID, rType, blobStore)
-	} else {
-		return datumToError(datum, flowID, blobStore)
+	return datumToValue(datum, f, rType, blobStore)
+}
+
+func readBlobBytes(blob *models.ModelBlobDatum, flowID string, blobStore blobstore.BlobStoreClient) ([]byte, error) {
+	var buf bytes.Buffer
+	var readErr error
+	err := blobStore.ReadBlob(flowID, blob.BlobID, blob.ContentType, func(b io.ReadCloser) { _, readErr = buf.ReadFrom(b) })
+	if err != nil {
+		return nil, err
 	}
+	return buf.Bytes(), readErr
 }
 
-func datumToValue(datum interface{}, flowID string, rType reflect.Type, blobStore blobstore.BlobStoreClient) interface{} {
+func datumToValue(datum interface{}, f *flow, rType reflect.Type, blobStore blobstore.BlobStoreClient) (interface{}, error) {
+	flowID := f.flowID
 	switch d := datum.(type) {
 
 	case *models.ModelBlobDatum:
-		if d.ContentType != GobMediaHeader {
-			panic(fmt.Sprintf("Unsupported blob content type %v", d.ContentType))
+		c, err := codecByMediaType(d.ContentType)
+		if err != nil {
+			return nil, err
 		}
 		var result interface{}
-		blobStore.ReadBlob(flowID, d.BlobID, d.ContentType, func(b io.ReadCloser) { result = decodeGob(b, rType) })
-		return result
+		var decodeErr error
+		err = blobStore.ReadBlob(flowID, d.BlobID, d.ContentType, func(b io.ReadCloser) { result, decodeErr = decodeValue(c, b, rType) })
+		if err != nil {
+			return nil, err
+		}
+		return result, decodeErr
 
 	case *models.ModelHTTPReqDatum:
-		var buf bytes.Buffer
-		blobStore.ReadBlob(flowID, d.Body.BlobID, d.Body.ContentType, func(b io.ReadCloser) { buf.ReadFrom(b) })
-		var headers http.Header
+		body, err := readBlobBytes(d.Body, flowID, blobStore)
+		if err != nil {
+			return nil, err
+		}
+		headers := make(http.Header)
 		for _, header := range d.Headers {
 			headers.Add(header.Key, header.Value)
 		}
-		return &HTTPRequest{Body: buf.Bytes(), Headers: headers, Method: string(d.Method)}
+		return &HTTPRequest{Body: body, Headers: headers, Method: string(d.Method)}, nil
 
 	case *models.ModelHTTPRespDatum:
-		var buf bytes.Buffer
-		blobStore.ReadBlob(flowID, d.Body.BlobID, d.Body.ContentType, func(b io.ReadCloser) { buf.ReadFrom(b) })
+		body, err := readBlobBytes(d.Body, flowID, blobStore)
+		if err != nil {
+			return nil, err
+		}
 		headers := make(http.Header)
 		for _, header := range d.Headers {
 			headers.Add(header.Key, header.Value)
 		}
-		return &HTTPResponse{Body: buf.Bytes(), Headers: headers, StatusCode: d.StatusCode}
+		resp := &HTTPResponse{Body: body, Headers: headers, StatusCode: d.StatusCode}
+		if reflect.TypeOf(resp).AssignableTo(rType) {
+			return resp, nil
+		}
+		// e.g. InvokeFunctionJSON, decode the response body to the stage's type
+		return decodeResponse(resp, d.Body.ContentType, rType)
 
 	case *models.ModelStageRefDatum:
-		return &flowFuture{flow: CurrentFlow().(*flow), stageID: d.StageID}
+		return &flowFuture{flow: f, stageID: d.StageID}, nil
 
 	case *models.ModelStatusDatum:
-		// TODO turn this into an iota in the API
-		return fmt.Sprintf("%v", d.Type)
+		// termination hooks receive the status as models.ModelStatusDatumType,
+		// or as any other type it converts to such as string
+		status := reflect.ValueOf(d.Type)
+		if !status.Type().ConvertibleTo(rType) {
+			return nil, fmt.Errorf("Flow status cannot be decoded to %v", rType)
+		}
+		return status.Convert(rType).Interface(), nil
 
 	default:
-		panic(fmt.Sprintf("Successful result %v cannot be decoded to go type", reflect.TypeOf(datum)))
+		return nil, fmt.Errorf("Successful result %v cannot be decoded to go type", reflect.TypeOf(datum))
+	}
+}
+
+func decodeResponse(resp *HTTPResponse, contentType string, rType reflect.Type) (interface{}, error) {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &FunctionError{StatusCode: resp.StatusCode, Headers: resp.Headers, Body: resp.Body}
+	}
+	if ct := resp.Headers.Get(ContentTypeHeader); ct != "" {
+		contentType = ct
 	}
+	c, err := codecByMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	return decodeValue(c, bytes.NewReader(resp.Body), rType)
 }
 
-func datumToError(datum interface{}, flowID string, blobStore blobstore.BlobStoreClient) error {
+func datumToError(datum interface{}, flowID string, blobStore blobstore.BlobStoreClient) (failure error, err error) {
 	switch d := datum.(type) {
 
 	case *models.ModelBlobDatum:
-		if d.ContentType != JSONMediaHeader {
-			panic(fmt.Sprintf("Unsupported blob content type for error %v", d.ContentType))
+		if mediaType, _, _ := mime.ParseMediaType(d.ContentType); mediaType != JSONMediaHeader {
+			return nil, fmt.Errorf("Unsupported blob content type for error %v", d.ContentType)
 		}
-		var err error
-		blobStore.ReadBlob(flowID, d.BlobID, d.ContentType, func(b io.ReadCloser) { err = decodeError(b) })
-		return err
+		var decodeErr error
+		if err = blobStore.ReadBlob(flowID, d.BlobID, d.ContentType, func(b io.ReadCloser) { failure, decodeErr = decodeError(b) }); err != nil {
+			return nil, err
+		}
+		return failure, decodeErr
 
 	case *models.ModelErrorDatum:
-		return errors.New(fmt.Sprintf("Platform error %v: %v", d.Type, d.Message))
+		return &PlatformError{Kind: d.Type, Message: d.Message}, nil
 
 	case *models.ModelHTTPRespDatum:
-		var buf bytes.Buffer
-		blobStore.ReadBlob(flowID, d.Body.BlobID, d.Body.ContentType, func(b io.ReadCloser) { buf.ReadFrom(b) })
-		// use response body to create the error message
-		return errors.New(string(buf.Bytes()))
+		body, err := readBlobBytes(d.Body, flowID, blobStore)
+		if err != nil {
+			return nil, err
+		}
+		headers := make(http.Header)
+		for _, header := range d.Headers {
+			headers.Add(header.Key, header.Value)
+		}
+		return &FunctionError{StatusCode: d.StatusCode, Headers: headers, Body: body}, nil
 
 	default:
-		panic(fmt.Sprintf("Failure result %v cannot be decoded to go type", reflect.TypeOf(datum)))
+		return nil, fmt.Errorf("Failure result %v cannot be decoded to go type", reflect.TypeOf(datum))
 	}
 }
 
-// errors cannot be encoded using gobs, so we just extract the message and encode with json
+// errors cannot be encoded using gobs, so we just extract the message and encode with json.
+// Errors of types known to the library are encoded in full so they can be unpacked again.
 type ErrorResult struct {
-	Error string `json:"error"`
+	Error        string             `json:"error"`
+	Panic        *PanicError        `json:"panic,omitempty"`
+	Platform     *PlatformError     `json:"platform,omitempty"`
+	Function     *FunctionError     `json:"function,omitempty"`
+	Continuation *ContinuationError `json:"continuation,omitempty"`
 }
 
 func (e *ErrorResult) Err() error {
+	switch {
+	case e.Panic != nil:
+		return e.Panic
+	case e.Platform != nil:
+		return e.Platform
+	case e.Function != nil:
+		return e.Function
+	case e.Continuation != nil:
+		return e.Continuation
+	}
 	return errors.New(e.Error)
 }
 
-func decodeError(r io.Reader) error {
+func decodeError(r io.Reader) (failure error, err error) {
 	result := new(ErrorResult)
 	if err := json.NewDecoder(r).Decode(result); err != nil {
-		panic("Failed to decode error result")
-	}
-	return result.Err()
-}
-
-func decodeGob(r io.Reader, t reflect.Type) interface{} {
-	if t == nil {
-		panic("Decode type could not be inferred")
-	}
-	dec := gob.NewDecoder(r)
-	var v reflect.Value
-	if t.Kind() == reflect.Ptr {
-		v = reflect.New(t.Elem())
-	} else {
-		v = reflect.New(t)
-	}
-	if err := dec.Decode(v.Interface()); err != nil {
-		panic("Failed to decode gob: " + err.Error())
-	}
-
-	if t.Kind() == reflect.Ptr {
-		return v.Interface()
+		return nil, fmt.Errorf("Failed to decode error result: %v", err)
 	}
-	return v.Elem().Interface()
+	return result.Err(), nil
 }
@@ -0,0 +1,275 @@
+// Package emulator serves the flow service API of models/model.swagger.json
+// and the blob store from memory, as a local stand-in for the Fn flow
+// service. Continuations and invoked functions are called over HTTP at the
+// URLs configured for their function IDs.
+package emulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fnproject/flow-lib-go/blobstore"
+	"github.com/fnproject/flow-lib-go/internal/engine"
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+// Server is an http.Handler serving the flow service and blob store APIs
+type Server struct {
+	engine       *engine.Engine
+	blobStore    blobstore.BlobStoreClient
+	hc           *http.Client
+	functionURLs map[string]string
+	defaultURL   string
+}
+
+type Option func(*Server)
+
+// WithFunctionURL sets the URL that continuations of flows created by the
+// function, and invocations of it from InvokeFunction stages, are sent to
+func WithFunctionURL(functionID string, url string) Option {
+	return func(s *Server) {
+		s.functionURLs[functionID] = url
+	}
+}
+
+// WithDefaultFunctionURL sets the URL of functions without a URL of their own
+func WithDefaultFunctionURL(url string) Option {
+	return func(s *Server) {
+		s.defaultURL = url
+	}
+}
+
+// WithBlobStore sets the blob store served under /blobs, which is in
+// memory by default
+func WithBlobStore(blobStore blobstore.BlobStoreClient) Option {
+	return func(s *Server) {
+		s.blobStore = blobStore
+	}
+}
+
+// WithHTTPClient overrides the http client used to invoke functions
+func WithHTTPClient(hc *http.Client) Option {
+	return func(s *Server) {
+		s.hc = hc
+	}
+}
+
+func New(opts ...Option) *Server {
+	s := &Server{
+		blobStore:    blobstore.NewMemoryBlobStore(),
+		hc:           &http.Client{Timeout: 10 * time.Minute},
+		functionURLs: make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.engine = engine.New(&httpInvoker{s}, engine.RealClock)
+	return s
+}
+
+func (s *Server) functionURL(functionID string) (string, error) {
+	if url, ok := s.functionURLs[functionID]; ok {
+		return url, nil
+	}
+	if s.defaultURL != "" {
+		return s.defaultURL, nil
+	}
+	return "", fmt.Errorf("No URL configured for function %s", functionID)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case path[0] == "blobs" && len(path) == 2 && r.Method == http.MethodPost:
+		s.writeBlob(w, r, path[1])
+	case path[0] == "blobs" && len(path) == 3 && r.Method == http.MethodGet:
+		s.readBlob(w, r, path[1], path[2])
+	case len(path) < 2 || path[0] != "v1":
+		writeError(w, http.StatusNotFound, errors.New("Not found"))
+	case len(path) == 2 && path[1] == "stream" && r.Method == http.MethodGet:
+		s.streamLifecycle(w, r)
+	case len(path) == 2 && path[1] == "flows" && r.Method == http.MethodPost:
+		s.createGraph(w, r)
+	case len(path) == 3 && path[1] == "flows" && r.Method == http.MethodGet:
+		s.getGraphState(w, r, path[2])
+	case len(path) == 4 && path[1] == "flows":
+		s.serveFlow(w, r, path[2], path[3])
+	case len(path) == 6 && path[1] == "flows" && path[3] == "stages":
+		s.serveStage(w, r, path[2], path[4], path[5])
+	default:
+		writeError(w, http.StatusNotFound, errors.New("Not found"))
+	}
+}
+
+func (s *Server) serveFlow(w http.ResponseWriter, r *http.Request, flowID string, op string) {
+	switch {
+	case op == "stream" && r.Method == http.MethodGet:
+		s.streamEvents(w, r, flowID)
+	case r.Method != http.MethodPost:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %s not allowed", r.Method))
+	case op == "commit":
+		s.commit(w, r, flowID)
+	case op == "delay":
+		s.addDelay(w, r, flowID)
+	case op == "invoke":
+		s.addInvokeFunction(w, r, flowID)
+	case op == "stage":
+		s.addStage(w, r, flowID)
+	case op == "value":
+		s.addValueStage(w, r, flowID)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("Not found"))
+	}
+}
+
+func (s *Server) serveStage(w http.ResponseWriter, r *http.Request, flowID string, stageID string, op string) {
+	switch {
+	case op == "await" && r.Method == http.MethodGet:
+		s.awaitStageResult(w, r, flowID, stageID)
+	case op == "complete" && r.Method == http.MethodPost:
+		s.completeStageExternally(w, r, flowID, stageID)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("Not found"))
+	}
+}
+
+func (s *Server) createGraph(w http.ResponseWriter, r *http.Request) {
+	var req models.ModelCreateGraphRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	flowID := s.engine.CreateFlow(req.FunctionID)
+	writeJSON(w, &models.ModelCreateGraphResponse{FlowID: flowID})
+}
+
+func (s *Server) getGraphState(w http.ResponseWriter, r *http.Request, flowID string) {
+	state, err := s.engine.State(flowID)
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	writeJSON(w, state)
+}
+
+func (s *Server) addStage(w http.ResponseWriter, r *http.Request, flowID string) {
+	var req models.ModelAddStageRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	stageID, err := s.engine.AddStage(flowID, req.Operation, req.Closure, req.Deps, req.CodeLocation, req.Signal)
+	writeStage(w, flowID, stageID, err)
+}
+
+func (s *Server) addValueStage(w http.ResponseWriter, r *http.Request, flowID string) {
+	var req models.ModelAddCompletedValueStageRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Value == nil || req.Value.Datum == nil {
+		writeError(w, http.StatusBadRequest, errors.New("Missing value"))
+		return
+	}
+	stageID, err := s.engine.AddValue(flowID, req.Value, req.CodeLocation)
+	writeStage(w, flowID, stageID, err)
+}
+
+func (s *Server) addDelay(w http.ResponseWriter, r *http.Request, flowID string) {
+	var req models.ModelAddDelayStageRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	stageID, err := s.engine.AddDelay(flowID, time.Duration(req.DelayMs)*time.Millisecond, req.CodeLocation)
+	writeStage(w, flowID, stageID, err)
+}
+
+func (s *Server) addInvokeFunction(w http.ResponseWriter, r *http.Request, flowID string) {
+	var req models.ModelAddInvokeFunctionStageRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Arg == nil {
+		writeError(w, http.StatusBadRequest, errors.New("Missing function argument"))
+		return
+	}
+	stageID, err := s.engine.AddInvoke(flowID, req.FunctionID, req.Arg, req.CodeLocation)
+	writeStage(w, flowID, stageID, err)
+}
+
+func (s *Server) awaitStageResult(w http.ResponseWriter, r *http.Request, flowID string, stageID string) {
+	ctx := r.Context()
+	if timeout := r.URL.Query().Get("timeout_ms"); timeout != "" {
+		ms, err := strconv.Atoi(timeout)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid timeout_ms: %v", err))
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+		defer cancel()
+	}
+	result, err := s.engine.Await(ctx, flowID, stageID)
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	writeJSON(w, &models.ModelAwaitStageResultResponse{FlowID: flowID, StageID: stageID, Result: result})
+}
+
+func (s *Server) completeStageExternally(w http.ResponseWriter, r *http.Request, flowID string, stageID string) {
+	var req models.ModelCompleteStageExternallyRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Value == nil || req.Value.Datum == nil {
+		writeError(w, http.StatusBadRequest, errors.New("Missing value"))
+		return
+	}
+	ok, err := s.engine.Complete(flowID, stageID, req.Value)
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	writeJSON(w, &models.ModelCompleteStageExternallyResponse{FlowID: flowID, StageID: stageID, Successful: ok})
+}
+
+func (s *Server) commit(w http.ResponseWriter, r *http.Request, flowID string) {
+	if err := s.engine.Commit(flowID); err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	writeJSON(w, &models.ModelGraphRequestProcessedResponse{FlowID: flowID})
+}
+
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, flowID string) {
+	var fromSeq uint64
+	if from := r.URL.Query().Get("from_seq"); from != "" {
+		var err error
+		if fromSeq, err = strconv.ParseUint(from, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid from_seq: %v", err))
+			return
+		}
+	}
+	events, err := s.engine.Events(r.Context(), flowID, fromSeq)
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	stream(w, events)
+}
+
+func (s *Server) streamLifecycle(w http.ResponseWriter, r *http.Request) {
+	stream(w, s.engine.Lifecycle(r.Context()))
+}
+
+func writeStage(w http.ResponseWriter, flowID string, stageID string, err error) {
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	writeJSON(w, &models.ModelAddStageResponse{FlowID: flowID, StageID: stageID})
+}
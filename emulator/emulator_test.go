@@ -0,0 +1,274 @@
+package emulator_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	fdk "github.com/fnproject/fdk-go"
+	flow "github.com/fnproject/flow-lib-go"
+	"github.com/fnproject/flow-lib-go/blobstore"
+	"github.com/fnproject/flow-lib-go/emulator"
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+const functionID = "app/flow"
+
+func greet(name string) string {
+	return "hello " + name
+}
+
+func init() {
+	for _, action := range []interface{}{greet, strings.ToUpper} {
+		if err := flow.RegisterAction(action); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// startEmulator serves a flow function running build with the flow and
+// the request body through an emulator, pointing the flow library at the
+// emulator. It returns the URLs of the emulator and the function.
+func startEmulator(t *testing.T, build func(fl flow.Flow, input string) string) (string, string) {
+	fn := httptest.NewServer(emulator.FunctionHandler(functionID, flow.WithFlow(fdk.HandlerFunc(func(ctx context.Context, in io.Reader, out io.Writer) {
+		input, _ := ioutil.ReadAll(in)
+		io.WriteString(out, build(flow.FromContext(ctx), string(input)))
+	}))))
+	t.Cleanup(fn.Close)
+	srv := httptest.NewServer(emulator.New(emulator.WithFunctionURL(functionID, fn.URL)))
+	t.Cleanup(srv.Close)
+
+	t.Setenv("COMPLETER_BASE_URL", srv.URL)
+	blobstore.SetBlobStore(blobstore.NewHTTPBlobStoreClient(srv.URL+"/blobs", srv.Client()))
+	t.Cleanup(func() { blobstore.SetBlobStore(nil) })
+	return srv.URL, fn.URL
+}
+
+// invoke invokes the function at url, returning the response body
+func invoke(t *testing.T, url string, body string) string {
+	t.Helper()
+	resp, err := http.Post(url, "text/plain", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	out, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", resp.StatusCode, out)
+	}
+	return string(out)
+}
+
+// awaitState returns the state of a flow once all its stages are complete
+func awaitState(t *testing.T, flowID string) *flow.GraphState {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for {
+		g, err := flow.InspectContext(ctx, flowID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(g.Incomplete()) == 0 {
+			return g
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("flow %s didn't complete: %v", flowID, g.Incomplete())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestFlowRoundTrip(t *testing.T) {
+	_, fnURL := startEmulator(t, func(fl flow.Flow, input string) string {
+		f := fl.Supply(flow.Bind(greet, input)).ThenApply(strings.ToUpper)
+		v, err := f.GetWithTimeout(5 * time.Second)
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprintf("%s %v", f.Ref().FlowID, v)
+	})
+	out := strings.SplitN(invoke(t, fnURL, "world"), " ", 2)
+	if len(out) != 2 || out[1] != "HELLO WORLD" {
+		t.Fatalf("got %q, want the flow ID and HELLO WORLD", out)
+	}
+
+	g := awaitState(t, out[0])
+	if g.FunctionID != functionID || len(g.Stages) != 2 {
+		t.Fatalf("got %+v, want the two stages of %s", g, functionID)
+	}
+	for _, s := range g.Stages {
+		if s.Status != flow.StageSuccessful {
+			t.Errorf("got stage %s %s, want it successful", s.ID, s.Status)
+		}
+	}
+}
+
+func TestAwaitTimeout(t *testing.T) {
+	_, fnURL := startEmulator(t, func(fl flow.Flow, input string) string {
+		f := flow.EmptyFuture[string](fl)
+		_, err := f.GetWithTimeout(time.Second)
+		// let the flow terminate
+		f.Complete("done")
+		if err != flow.ErrAwaitTimeout {
+			return fmt.Sprintf("got %v, want ErrAwaitTimeout", err)
+		}
+		return "timed out"
+	})
+	if got := invoke(t, fnURL, ""); got != "timed out" {
+		t.Error(got)
+	}
+}
+
+func TestSignal(t *testing.T) {
+	_, fnURL := startEmulator(t, func(fl flow.Flow, input string) string {
+		f := flow.Signal[string](context.Background(), fl, "approved")
+		if f.Err() != nil {
+			return f.Err().Error()
+		}
+		return f.Ref().FlowID
+	})
+	flowID := invoke(t, fnURL, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ok, err := flow.SendSignalContext(ctx, flowID, "approved", "yes")
+	if err != nil || !ok {
+		t.Fatalf("got %v %v sending the signal, want true", ok, err)
+	}
+	g := awaitState(t, flowID)
+	if len(g.Stages) != 1 || g.Stages[0].Signal != "approved" || g.Stages[0].Status != flow.StageSuccessful {
+		t.Errorf("got %+v, want the signal's completed stage only", g.Stages)
+	}
+}
+
+// events streams the events of a completed flow from fromSeq
+func events(t *testing.T, flowID string, fromSeq uint64) []*models.ModelGraphEvent {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	evs, err := flow.WatchFlow(ctx, flowID, fromSeq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []*models.ModelGraphEvent
+	for ev := range evs {
+		got = append(got, ev)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("the stream didn't end with the flow")
+	}
+	return got
+}
+
+func TestStreamReplay(t *testing.T) {
+	_, fnURL := startEmulator(t, func(fl flow.Flow, input string) string {
+		return fl.CompletedValue(input).ThenApply(strings.ToUpper).Ref().FlowID
+	})
+	flowID := invoke(t, fnURL, "replayed")
+	all := events(t, flowID, 0)
+	if len(all) < 4 || all[0].GraphCreated == nil || all[len(all)-1].GraphCompleted == nil {
+		t.Fatalf("got %d events, want them from creation to completion", len(all))
+	}
+	for i, ev := range all {
+		if ev.Seq != uint64(i+1) {
+			t.Fatalf("got event %d with seq %d, want %d", i, ev.Seq, i+1)
+		}
+	}
+
+	from := all[1].Seq
+	replayed := events(t, flowID, from)
+	if len(replayed) != len(all)-int(from) {
+		t.Fatalf("got %d events from seq %d, want %d", len(replayed), from, len(all)-int(from))
+	}
+	for i, ev := range replayed {
+		if ev.Seq != all[int(from)+i].Seq {
+			t.Errorf("got seq %d, want %d", ev.Seq, all[int(from)+i].Seq)
+		}
+	}
+}
+
+func TestBlobs(t *testing.T) {
+	srvURL, _ := startEmulator(t, func(fl flow.Flow, input string) string { return "" })
+	store := blobstore.NewHTTPBlobStoreClient(srvURL+"/blobs", http.DefaultClient)
+
+	b, err := store.WriteBlob("flow", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.BlobLength != 5 || b.ContentType != "text/plain" {
+		t.Errorf("got %+v, want a text/plain blob of length 5", b)
+	}
+	var body []byte
+	err = store.ReadBlob("flow", b.BlobId, "text/plain", func(r io.ReadCloser) { body, _ = ioutil.ReadAll(r) })
+	if err != nil || string(body) != "hello" {
+		t.Errorf("got %q %v, want hello", body, err)
+	}
+
+	err = store.ReadBlob("flow", "missing", "text/plain", func(io.ReadCloser) { t.Error("read a missing blob") })
+	var blobErr *blobstore.BlobStoreError
+	if !errors.As(err, &blobErr) || blobErr.StatusCode != http.StatusNotFound {
+		t.Errorf("got %v, want a 404 BlobStoreError", err)
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	srvURL, fnURL := startEmulator(t, func(fl flow.Flow, input string) string {
+		return fl.CompletedValue(input).Ref().FlowID
+	})
+	flowID := invoke(t, fnURL, "done")
+	// the stream ends once the flow has terminated
+	events(t, flowID, 0)
+
+	value := `{"value":{"successful":true,"datum":{"empty":{}}}}`
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"unknown path", http.MethodGet, "/v2/flows", "", http.StatusNotFound},
+		{"unknown flow", http.MethodGet, "/v1/flows/missing", "", http.StatusNotFound},
+		{"commit unknown flow", http.MethodPost, "/v1/flows/missing/commit", "", http.StatusNotFound},
+		{"await unknown stage", http.MethodGet, "/v1/flows/" + flowID + "/stages/99/await", "", http.StatusNotFound},
+		{"add to terminated flow", http.MethodPost, "/v1/flows/" + flowID + "/value", value, http.StatusConflict},
+		{"invalid body", http.MethodPost, "/v1/flows", "{", http.StatusBadRequest},
+		{"invalid timeout", http.MethodGet, "/v1/flows/" + flowID + "/stages/0/await?timeout_ms=soon", "", http.StatusBadRequest},
+		{"method not allowed", http.MethodGet, "/v1/flows/" + flowID + "/commit", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srvURL+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var body struct {
+				Error string `json:"error"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == "" {
+				t.Errorf("got body error %q %v, want an error message", body.Error, err)
+			}
+			if resp.StatusCode != tt.code {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package emulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/fnproject/flow-lib-go/blobstore"
+	"github.com/fnproject/flow-lib-go/internal/engine"
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write response: %v\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(&errorResponse{Error: err.Error()})
+}
+
+// writeEngineError responds with the status the flow service uses for err
+func writeEngineError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, engine.ErrFlowNotFound), errors.Is(err, engine.ErrStageNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, engine.ErrFlowTerminated):
+		code = http.StatusConflict
+	case errors.Is(err, engine.ErrInvalidStage):
+		code = http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		code = http.StatusRequestTimeout
+	case errors.Is(err, context.Canceled):
+		return // the client has gone away
+	}
+	writeError(w, code, err)
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+// streamResult is the envelope of each event in a stream, one per line
+type streamResult[T any] struct {
+	Result T `json:"result"`
+}
+
+// stream writes events as newline-delimited JSON until the channel is closed
+func stream[T any](w http.ResponseWriter, events <-chan T) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	enc := json.NewEncoder(w)
+	for ev := range events {
+		if err := enc.Encode(&streamResult[T]{Result: ev}); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *Server) writeBlob(w http.ResponseWriter, r *http.Request, prefix string) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	b, err := s.blobStore.WriteBlob(prefix, contentType, r.Body)
+	if err != nil {
+		writeBlobError(w, err)
+		return
+	}
+	writeJSON(w, b)
+}
+
+func (s *Server) readBlob(w http.ResponseWriter, r *http.Request, prefix string, blobID string) {
+	contentType := r.Header.Get("Accept")
+	// stores that keep content types, such as the file and memory stores,
+	// serve blobs with the type they were written with
+	if typed, ok := s.blobStore.(interface {
+		ContentType(prefix string, blobID string) (string, error)
+	}); ok {
+		if stored, err := typed.ContentType(prefix, blobID); err == nil {
+			contentType = stored
+		}
+	}
+	err := s.blobStore.ReadBlob(prefix, blobID, contentType, func(body io.ReadCloser) {
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.WriteHeader(http.StatusOK)
+		io.Copy(w, body)
+	})
+	if err != nil {
+		writeBlobError(w, err)
+	}
+}
+
+func writeBlobError(w http.ResponseWriter, err error) {
+	var blobErr *blobstore.BlobStoreError
+	if errors.As(err, &blobErr) && blobErr.StatusCode != 0 {
+		writeError(w, blobErr.StatusCode, err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
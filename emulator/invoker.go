@@ -0,0 +1,129 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	fdk "github.com/fnproject/fdk-go"
+	flow "github.com/fnproject/flow-lib-go"
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+// httpInvoker invokes continuations and functions at their configured URLs
+type httpInvoker struct {
+	s *Server
+}
+
+func (i *httpInvoker) InvokeStage(flowID string, functionID string, stageID string, closure *models.ModelBlobDatum, args []*models.ModelCompletionResult) (*models.ModelCompletionResult, error) {
+	url, err := i.s.functionURL(functionID)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(&flow.InvokeStageRequest{FlowID: flowID, StageID: stageID, Closure: closure, Args: args})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(flow.FlowIDHeader, flowID)
+	req.Header.Set(flow.StageIDHeader, stageID)
+	req.Header.Set(flow.ContentTypeHeader, flow.JSONMediaHeader)
+
+	resp, err := i.s.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Continuation invocation failed with status %d: %s", resp.StatusCode, msg)
+	}
+
+	var out flow.InvokeStageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("Failed to decode continuation response: %v", err)
+	}
+	return out.Result, nil
+}
+
+func (i *httpInvoker) InvokeFunction(flowID string, functionID string, arg *models.ModelHTTPReqDatum) (*models.ModelHTTPRespDatum, error) {
+	url, err := i.s.functionURL(functionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	if arg.Body != nil {
+		var readErr error
+		err := i.s.blobStore.ReadBlob(flowID, arg.Body.BlobID, arg.Body.ContentType, func(b io.ReadCloser) { _, readErr = body.ReadFrom(b) })
+		if err == nil {
+			err = readErr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	method := strings.ToUpper(string(arg.Method))
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequest(method, url, &body)
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range arg.Headers {
+		req.Header.Add(header.Key, header.Value)
+	}
+
+	resp, err := i.s.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	contentType := resp.Header.Get(flow.ContentTypeHeader)
+	if contentType == "" {
+		contentType = flow.OctetStreamMediaHeader
+	}
+	b, err := i.s.blobStore.WriteBlob(flowID, contentType, resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	datum := &models.ModelHTTPRespDatum{Body: b.BlobDatum(), StatusCode: int32(resp.StatusCode)}
+	for key, values := range resp.Header {
+		for _, value := range values {
+			datum.Headers = append(datum.Headers, &models.ModelHTTPHeader{Key: key, Value: value})
+		}
+	}
+	return datum, nil
+}
+
+// FunctionHandler serves an fdk handler over plain HTTP, so a flow
+// function can run locally behind the URL configured for it. The flow
+// library in the function must be pointed at the emulator by setting
+// COMPLETER_BASE_URL.
+func FunctionHandler(functionID string, handler fdk.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fnctx := &fnContext{header: r.Header, config: map[string]string{"FN_FN_ID": functionID}}
+		handler.Serve(fdk.WithContext(r.Context(), fnctx), r.Body, w)
+	})
+}
+
+type fnContext struct {
+	header http.Header
+	config map[string]string
+}
+
+func (c *fnContext) Config() map[string]string { return c.config }
+func (c *fnContext) Header() http.Header       { return c.header }
+func (c *fnContext) ContentType() string       { return c.header.Get(flow.ContentTypeHeader) }
+func (c *fnContext) CallID() string            { return "" }
+func (c *fnContext) AppID() string             { return c.config["FN_APP_ID"] }
+func (c *fnContext) FnID() string              { return c.config["FN_FN_ID"] }
@@ -0,0 +1,114 @@
+package flow
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"reflect"
+)
+
+// ValueCodec encodes and decodes stage values as blobs of a content type.
+// Values are decoded using the codec registered for the blob's content type,
+// so a flow can consume values produced by other languages and tools.
+type ValueCodec interface {
+	ContentType() string
+	Encode(w io.Writer, value interface{}) error
+	// Decode decodes into v, which is a pointer to a value of the target type
+	Decode(r io.Reader, v interface{}) error
+}
+
+var codecs = map[string]ValueCodec{
+	GobMediaHeader:  gobCodec{},
+	JSONMediaHeader: jsonCodec{},
+}
+
+var typeCodecs = make(map[reflect.Type]string)
+
+// RegisterCodec registers a codec for its content type, replacing any
+// codec previously registered for it. Like RegisterAction, it should be
+// called from an init function.
+func RegisterCodec(c ValueCodec) {
+	codecs[c.ContentType()] = c
+}
+
+// UseCodecForType encodes values of the given type with the codec
+// registered for contentType, regardless of the flow's codec
+func UseCodecForType(t reflect.Type, contentType string) {
+	typeCodecs[t] = contentType
+}
+
+func codecFor(value interface{}, defaultType string) (ValueCodec, error) {
+	contentType, ok := typeCodecs[reflect.TypeOf(value)]
+	if !ok {
+		contentType = defaultType
+	}
+	return codecByType(contentType)
+}
+
+func codecByType(contentType string) (ValueCodec, error) {
+	c, ok := codecs[contentType]
+	if !ok {
+		return nil, fmt.Errorf("No codec registered for content type %v", contentType)
+	}
+	return c, nil
+}
+
+// codecByMediaType returns the codec for a content type that may have
+// parameters, e.g. "application/json; charset=utf-8"
+func codecByMediaType(contentType string) (ValueCodec, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid content type %v: %v", contentType, err)
+	}
+	return codecByType(mediaType)
+}
+
+func decodeValue(c ValueCodec, r io.Reader, t reflect.Type) (interface{}, error) {
+	if t == nil {
+		return nil, fmt.Errorf("Decode type could not be inferred")
+	}
+	var v reflect.Value
+	if t.Kind() == reflect.Ptr {
+		v = reflect.New(t.Elem())
+	} else {
+		v = reflect.New(t)
+	}
+	if err := c.Decode(r, v.Interface()); err != nil {
+		return nil, fmt.Errorf("Failed to decode %v: %v", c.ContentType(), err)
+	}
+
+	if t.Kind() == reflect.Ptr {
+		return v.Interface(), nil
+	}
+	return v.Elem().Interface(), nil
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string {
+	return GobMediaHeader
+}
+
+func (gobCodec) Encode(w io.Writer, value interface{}) error {
+	return gob.NewEncoder(w).Encode(value)
+}
+
+func (gobCodec) Decode(r io.Reader, v interface{}) error {
+	return gob.NewDecoder(r).Decode(v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return JSONMediaHeader
+}
+
+func (jsonCodec) Encode(w io.Writer, value interface{}) error {
+	return json.NewEncoder(w).Encode(value)
+}
+
+func (jsonCodec) Decode(r io.Reader, v interface{}) error {
+	return json.NewDecoder(r).Decode(v)
+}
@@ -0,0 +1,124 @@
+package flow
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fnproject/flow-lib-go/blobstore"
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+type codecTestValue struct {
+	Name  string
+	Count int
+	Tags  []string
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	values := []interface{}{
+		"hello",
+		42,
+		true,
+		[]string{"a", "b"},
+		codecTestValue{Name: "x", Count: 3, Tags: []string{"t"}},
+		&codecTestValue{Name: "y"},
+	}
+	for _, contentType := range []string{GobMediaHeader, JSONMediaHeader} {
+		for _, value := range values {
+			t.Run(contentType+"/"+reflect.TypeOf(value).String(), func(t *testing.T) {
+				store := blobstore.NewMemoryBlobStore()
+				result, err := valueToModel(value, "flow", store, contentType)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Datum.Blob == nil || result.Datum.Blob.ContentType != contentType {
+					t.Fatalf("got datum %+v, want a %s blob", result.Datum, contentType)
+				}
+				got, err := datumToValue(result.Datum.Blob, &flow{flowID: "flow"}, reflect.TypeOf(value), store)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, value) {
+					t.Errorf("got %#v, want %#v", got, value)
+				}
+			})
+		}
+	}
+}
+
+func TestCodecParameterisedContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        interface{}
+	}{
+		{"application/json; charset=utf-8", `{"Name":"x","Count":3}`, codecTestValue{Name: "x", Count: 3}},
+		{"application/json;charset=UTF-8", `"hello"`, "hello"},
+		{"Application/JSON", `[1,2]`, []int{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			store := blobstore.NewMemoryBlobStore()
+			b, err := store.WriteBlob("flow", tt.contentType, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := datumToValue(b.BlobDatum(), &flow{flowID: "flow"}, reflect.TypeOf(tt.want), store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+
+			resp := &HTTPResponse{StatusCode: 200, Headers: http.Header{ContentTypeHeader: {tt.contentType}}, Body: []byte(tt.body)}
+			if got, err = decodeResponse(resp, "", reflect.TypeOf(tt.want)); err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v from response, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodecUnknownContentType(t *testing.T) {
+	store := blobstore.NewMemoryBlobStore()
+	for _, contentType := range []string{"text/csv; charset=utf-8", "not a media type;"} {
+		b, err := store.WriteBlob("flow", contentType, strings.NewReader("x"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := datumToValue(b.BlobDatum(), &flow{flowID: "flow"}, reflect.TypeOf(""), store); err == nil {
+			t.Errorf("got no error decoding %q", contentType)
+		}
+	}
+}
+
+func TestErrorParameterisedContentType(t *testing.T) {
+	var body bytes.Buffer
+	if err := (jsonCodec{}).Encode(&body, &ErrorResult{Error: "boom"}); err != nil {
+		t.Fatal(err)
+	}
+	store := blobstore.NewMemoryBlobStore()
+	b, err := store.WriteBlob("flow", "application/json; charset=utf-8", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failure, err := datumToError(b.BlobDatum(), "flow", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failure == nil || failure.Error() != "boom" {
+		t.Errorf("got %v, want boom", failure)
+	}
+
+	var platformErr *PlatformError
+	failure, err = datumToError(&models.ModelErrorDatum{Type: models.ModelErrorDatumTypeStageTimeout}, "flow", store)
+	if err != nil || !errors.As(failure, &platformErr) {
+		t.Errorf("got %v %v, want a PlatformError", failure, err)
+	}
+}
@@ -0,0 +1,156 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/fnproject/flow-lib-go/blobstore"
+	"github.com/fnproject/flow-lib-go/models"
+	"github.com/go-openapi/runtime"
+)
+
+// ErrAwaitTimeout is returned when a stage result isn't available before
+// the deadline passed to Await or GetWithTimeout
+var ErrAwaitTimeout = errors.New("Timed out awaiting stage result")
+
+// ClientError reports a failed call to the flow service or blob store.
+// Futures whose stage couldn't be created carry a ClientError which is
+// returned from Get and Await.
+type ClientError struct {
+	Op         string // operation that failed, e.g. "create flow"
+	StatusCode int    // zero if no response was received
+	Err        error
+}
+
+func newClientError(op string, err error) *ClientError {
+	e := &ClientError{Op: op, Err: err}
+	var apiErr *runtime.APIError
+	var blobErr *blobstore.BlobStoreError
+	switch {
+	case errors.As(err, &apiErr):
+		e.StatusCode = apiErr.Code
+	case errors.As(err, &blobErr):
+		e.StatusCode = blobErr.StatusCode
+	}
+	return e
+}
+
+func (e *ClientError) Error() string {
+	return fmt.Sprintf("Failed to %s: %v", e.Op, e.Err)
+}
+
+func (e *ClientError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the operation may succeed if attempted again,
+// i.e. the request didn't reach the service or it failed transiently
+func (e *ClientError) Retryable() bool {
+	var blobErr *blobstore.BlobStoreError
+	if errors.As(e.Err, &blobErr) {
+		return blobErr.Retryable()
+	}
+	switch {
+	case e.StatusCode == http.StatusRequestTimeout, e.StatusCode == http.StatusTooManyRequests:
+		return true
+	case e.StatusCode >= 500:
+		return true
+	case e.StatusCode != 0:
+		return false
+	}
+	var netErr net.Error
+	return errors.As(e.Err, &netErr)
+}
+
+func awaitError(ctx context.Context, err error) error {
+	if apiErr, ok := err.(*runtime.APIError); ok && apiErr.Code == http.StatusRequestTimeout {
+		return ErrAwaitTimeout
+	}
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return ErrAwaitTimeout
+	case context.Canceled:
+		return context.Canceled
+	}
+	return newClientError("await stage result", err)
+}
+
+// PanicError is the failure of a stage whose action panicked. It can be
+// unpacked with errors.As in the Handle and Exceptionally actions of
+// dependent stages.
+type PanicError struct {
+	Action string `json:"action"` // key of the action that panicked
+	Value  string `json:"value"`  // the value passed to panic, formatted with %v
+	Stack  string `json:"stack"`  // stack trace of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("Action %s panicked: %s", e.Action, e.Value)
+}
+
+// PlatformError is the failure of a stage raised by the flow service
+// rather than by an action, e.g. because the stage timed out. Compare it
+// against the Err sentinels below using errors.Is.
+type PlatformError struct {
+	Kind    models.ModelErrorDatumType `json:"kind"`
+	Message string                     `json:"message,omitempty"`
+}
+
+func (e *PlatformError) Error() string {
+	return fmt.Sprintf("Platform error %v: %v", e.Kind, e.Message)
+}
+
+// Is matches sentinels, which have no message, by kind
+func (e *PlatformError) Is(target error) bool {
+	t, ok := target.(*PlatformError)
+	return ok && t.Message == "" && t.Kind == e.Kind
+}
+
+var (
+	ErrUnknownPlatformError = &PlatformError{Kind: models.ModelErrorDatumTypeUnknownError}
+	ErrStageTimeout         = &PlatformError{Kind: models.ModelErrorDatumTypeStageTimeout}
+	ErrStageFailed          = &PlatformError{Kind: models.ModelErrorDatumTypeStageFailed}
+	ErrFunctionTimeout      = &PlatformError{Kind: models.ModelErrorDatumTypeFunctionTimeout}
+	ErrFunctionInvokeFailed = &PlatformError{Kind: models.ModelErrorDatumTypeFunctionInvokeFailed}
+	ErrStageLost            = &PlatformError{Kind: models.ModelErrorDatumTypeStageLost}
+	ErrInvalidStageResponse = &PlatformError{Kind: models.ModelErrorDatumTypeInvalidStageResponse}
+)
+
+// FunctionError is the failure of an InvokeFunction stage whose function
+// responded with an error status. FunctionID is only known when awaiting
+// the invocation's own future, and is empty in the actions of dependent stages.
+type FunctionError struct {
+	FunctionID string      `json:"function_id,omitempty"`
+	StatusCode int32       `json:"status_code"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+}
+
+func (e *FunctionError) Error() string {
+	if e.FunctionID != "" {
+		return fmt.Sprintf("Function %s failed with status %d: %s", e.FunctionID, e.StatusCode, e.Body)
+	}
+	return fmt.Sprintf("Function failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// ContinuationError is the failure of a stage whose action couldn't be
+// invoked, typically because a flow started by an earlier deployment refers
+// to an action that is no longer registered or whose signature changed.
+type ContinuationError struct {
+	Action    string `json:"action,omitempty"`    // key of the action
+	Signature string `json:"signature,omitempty"` // type of the registered action
+	Reason    string `json:"reason"`
+}
+
+func (e *ContinuationError) Error() string {
+	switch {
+	case e.Action == "":
+		return fmt.Sprintf("Failed to invoke continuation: %s", e.Reason)
+	case e.Signature == "":
+		return fmt.Sprintf("Failed to invoke continuation %s: %s", e.Action, e.Reason)
+	}
+	return fmt.Sprintf("Failed to invoke continuation %s %s: %s", e.Action, e.Signature, e.Reason)
+}
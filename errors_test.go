@@ -0,0 +1,190 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fnproject/flow-lib-go/blobstore"
+	"github.com/fnproject/flow-lib-go/models"
+	"github.com/go-openapi/runtime"
+)
+
+// statusServer returns a server answering every request with code
+func statusServer(t *testing.T, code int) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(code), code)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testFlowClient returns a client of the flow service at baseURL, as used
+// by flows
+func testFlowClient(t *testing.T, baseURL string, store blobstore.BlobStoreClient) *remoteFlowClient {
+	t.Setenv("COMPLETER_BASE_URL", baseURL)
+	client, err := newFlowClient(context.Background(), &flowOptions{contentType: JSONMediaHeader, blobStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestClientErrorStatus(t *testing.T) {
+	tests := []struct {
+		code      int
+		retryable bool
+	}{
+		{http.StatusBadRequest, false},
+		{http.StatusNotFound, false},
+		{http.StatusConflict, false},
+		{http.StatusRequestTimeout, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusBadGateway, true},
+		{http.StatusServiceUnavailable, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.code), func(t *testing.T) {
+			client := testFlowClient(t, statusServer(t, tt.code).URL, blobstore.NewMemoryBlobStore())
+			_, err := client.createFlow("fn")
+
+			var clientErr *ClientError
+			if !errors.As(err, &clientErr) {
+				t.Fatalf("got %T %v, want a ClientError", err, err)
+			}
+			if clientErr.Op != "create flow" {
+				t.Errorf("got op %q, want %q", clientErr.Op, "create flow")
+			}
+			if clientErr.StatusCode != tt.code {
+				t.Errorf("got status %d, want %d", clientErr.StatusCode, tt.code)
+			}
+			if clientErr.Retryable() != tt.retryable {
+				t.Errorf("got retryable %v, want %v", clientErr.Retryable(), tt.retryable)
+			}
+			var apiErr *runtime.APIError
+			if !errors.As(err, &apiErr) || apiErr.Code != tt.code {
+				t.Errorf("got %v, want it to wrap an APIError with code %d", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestClientErrorUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	baseURL := srv.URL
+	srv.Close()
+
+	client := testFlowClient(t, baseURL, blobstore.NewMemoryBlobStore())
+	_, err := client.createFlow("fn")
+
+	var clientErr *ClientError
+	if !errors.As(err, &clientErr) {
+		t.Fatalf("got %T %v, want a ClientError", err, err)
+	}
+	if clientErr.StatusCode != 0 {
+		t.Errorf("got status %d, want 0", clientErr.StatusCode)
+	}
+	if !clientErr.Retryable() {
+		t.Error("got a non-retryable error for an unreachable service")
+	}
+}
+
+func TestClientErrorNotRetryableWithoutResponse(t *testing.T) {
+	// an error that isn't from the network, e.g. failing to encode a value
+	err := newClientError("add completed value stage", errors.New("boom"))
+	if err.StatusCode != 0 || err.Retryable() {
+		t.Errorf("got status %d retryable %v, want 0 and false", err.StatusCode, err.Retryable())
+	}
+}
+
+func TestClientErrorBlobStoreStatus(t *testing.T) {
+	tests := []struct {
+		code      int
+		retryable bool
+	}{
+		{http.StatusBadRequest, false},
+		{http.StatusForbidden, false},
+		{http.StatusRequestTimeout, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.code), func(t *testing.T) {
+			// the flow service is never reached as the value's blob can't be
+			// written
+			store := blobstore.NewHTTPBlobStoreClient(statusServer(t, tt.code).URL, http.DefaultClient)
+			client := testFlowClient(t, statusServer(t, http.StatusOK).URL, store)
+			_, err := client.completedValue("flow", "value", newCodeLoc())
+
+			var clientErr *ClientError
+			if !errors.As(err, &clientErr) {
+				t.Fatalf("got %T %v, want a ClientError", err, err)
+			}
+			if clientErr.StatusCode != tt.code {
+				t.Errorf("got status %d, want %d", clientErr.StatusCode, tt.code)
+			}
+			if clientErr.Retryable() != tt.retryable {
+				t.Errorf("got retryable %v, want %v", clientErr.Retryable(), tt.retryable)
+			}
+			var blobErr *blobstore.BlobStoreError
+			if !errors.As(err, &blobErr) {
+				t.Fatalf("got %v, want it to wrap a BlobStoreError", err)
+			}
+			if blobErr.Op != "write" || blobErr.StatusCode != tt.code {
+				t.Errorf("got blob %s status %d, want write status %d", blobErr.Op, blobErr.StatusCode, tt.code)
+			}
+		})
+	}
+}
+
+func TestAwaitErrorTimeout(t *testing.T) {
+	apiErr := runtime.NewAPIError("unknown error", nil, http.StatusRequestTimeout)
+	if err := awaitError(context.Background(), apiErr); err != ErrAwaitTimeout {
+		t.Errorf("got %v, want ErrAwaitTimeout", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := awaitError(ctx, errors.New("canceled")); err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+
+	apiErr = runtime.NewAPIError("unknown error", nil, http.StatusNotFound)
+	var clientErr *ClientError
+	if err := awaitError(context.Background(), apiErr); !errors.As(err, &clientErr) || clientErr.StatusCode != http.StatusNotFound {
+		t.Errorf("got %v, want a ClientError with status 404", err)
+	}
+}
+
+func TestPlatformErrorIs(t *testing.T) {
+	timeout := &PlatformError{Kind: models.ModelErrorDatumTypeStageTimeout, Message: "Stage timed out"}
+	tests := []struct {
+		err    error
+		target error
+		want   bool
+	}{
+		{timeout, ErrStageTimeout, true},
+		{timeout, ErrStageFailed, false},
+		{timeout, ErrFunctionTimeout, false},
+		{fmt.Errorf("stage 3: %w", timeout), ErrStageTimeout, true},
+		{&PlatformError{Kind: models.ModelErrorDatumTypeStageLost}, ErrStageLost, true},
+		// only sentinels, which have no message, match by kind
+		{timeout, &PlatformError{Kind: models.ModelErrorDatumTypeStageTimeout, Message: "other"}, false},
+		{errors.New("Stage timed out"), ErrStageTimeout, false},
+	}
+	for _, tt := range tests {
+		if got := errors.Is(tt.err, tt.target); got != tt.want {
+			t.Errorf("errors.Is(%v, %v) = %v, want %v", tt.err, tt.target, got, tt.want)
+		}
+	}
+
+	var platformErr *PlatformError
+	if !errors.As(fmt.Errorf("stage 3: %w", timeout), &platformErr) || platformErr != timeout {
+		t.Errorf("got %v, want the wrapped PlatformError", platformErr)
+	}
+}
@@ -0,0 +1,63 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+// Decoder reads events from a flow's event stream, or a saved copy of one:
+// newline-delimited JSON events, each optionally wrapped in the stream's
+// result envelope
+type Decoder struct {
+	dec *json.Decoder
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{dec: json.NewDecoder(r)}
+}
+
+// Next returns the next event, or io.EOF at the end of the stream. Events
+// of types this package doesn't know are skipped.
+func (d *Decoder) Next() (Event, error) {
+	for {
+		var envelope struct {
+			Result *models.ModelGraphEvent `json:"result"`
+			Error  json.RawMessage         `json:"error"`
+			models.ModelGraphEvent
+		}
+		if err := d.dec.Decode(&envelope); err != nil {
+			return nil, err
+		}
+		if envelope.Error != nil {
+			return nil, fmt.Errorf("Stream failed: %s", envelope.Error)
+		}
+		m := &envelope.ModelGraphEvent
+		if envelope.Result != nil {
+			m = envelope.Result
+		}
+		ev, err := FromModel(m)
+		if err == ErrUnknownEvent {
+			continue
+		}
+		return ev, err
+	}
+}
+
+// ReadAll returns all the events of a stream
+func ReadAll(r io.Reader) ([]Event, error) {
+	d := NewDecoder(r)
+	var evs []Event
+	for {
+		ev, err := d.Next()
+		if err == io.EOF {
+			return evs, nil
+		}
+		if err != nil {
+			return evs, err
+		}
+		evs = append(evs, ev)
+	}
+}
@@ -0,0 +1,186 @@
+// Package events converts the events of a flow's event stream, as delivered
+// by flow.WatchFlow or saved from the flow service's stream endpoint, into
+// typed values for use in type switches:
+//
+//	switch ev := ev.(type) {
+//	case *events.StageCompleted:
+//		fmt.Println(ev.StageID, ev.Result)
+//	case *events.GraphTerminating:
+//		fmt.Println(ev.Status)
+//	}
+package events
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fnproject/flow-lib-go/models"
+	strfmt "github.com/go-openapi/strfmt"
+)
+
+// Event is one of the event types of this package
+type Event interface {
+	// EventHeader returns the fields common to all events
+	EventHeader() Header
+	isEvent()
+}
+
+// Header holds the fields common to all events
+type Header struct {
+	FlowID string
+	Seq    uint64    // position in the flow's event stream, from 1
+	Time   time.Time // when the event happened
+}
+
+func (h Header) EventHeader() Header {
+	return h
+}
+
+// GraphCreated is the first event of a flow
+type GraphCreated struct {
+	Header
+	FunctionID string // of the function that created the flow
+}
+
+// GraphCommitted is sent once the function that created the flow returns,
+// after which the flow terminates when its stages are complete
+type GraphCommitted struct {
+	Header
+}
+
+// GraphTerminating is sent when the flow terminates, before its termination
+// hooks run
+type GraphTerminating struct {
+	Header
+	FunctionID string
+	Status     models.ModelStatusDatumType
+}
+
+// GraphCompleted is the last event of a flow
+type GraphCompleted struct {
+	Header
+	FunctionID string
+}
+
+// StageAdded is sent when a stage is added to the flow
+type StageAdded struct {
+	Header
+	StageID      string
+	Op           models.ModelCompletionOperation
+	Dependencies []string
+	Closure      *Blob // nil unless the stage runs a continuation
+	CodeLocation string
+	CallerID     string // of the stage whose continuation added this stage, if any
+}
+
+// StageCompleted is sent when a stage gets its result
+type StageCompleted struct {
+	Header
+	StageID string
+	Result  Result
+}
+
+// StageComposed is sent when a thenCompose or exceptionallyCompose stage
+// takes on the result of the stage returned by its continuation
+type StageComposed struct {
+	Header
+	StageID         string
+	ComposedStageID string
+}
+
+// DelayScheduled is sent when a delay stage is added, with the time it
+// completes at
+type DelayScheduled struct {
+	Header
+	StageID string
+	At      time.Time
+}
+
+// FunctionStarted is sent when the flow service invokes a function, either
+// to run a stage's continuation or for an InvokeFunction stage
+type FunctionStarted struct {
+	Header
+	StageID    string
+	FunctionID string
+}
+
+// FunctionCompleted is sent when an invocation started by FunctionStarted
+// returns
+type FunctionCompleted struct {
+	Header
+	StageID string
+	CallID  string
+	Result  Result
+}
+
+func (*GraphCreated) isEvent()      {}
+func (*GraphCommitted) isEvent()    {}
+func (*GraphTerminating) isEvent()  {}
+func (*GraphCompleted) isEvent()    {}
+func (*StageAdded) isEvent()        {}
+func (*StageCompleted) isEvent()    {}
+func (*StageComposed) isEvent()     {}
+func (*DelayScheduled) isEvent()    {}
+func (*FunctionStarted) isEvent()   {}
+func (*FunctionCompleted) isEvent() {}
+
+// ErrUnknownEvent is returned for events without any of the event fields
+// this package knows of set
+var ErrUnknownEvent = errors.New("Unknown event type")
+
+// FromModel converts an event of the flow service API
+func FromModel(m *models.ModelGraphEvent) (Event, error) {
+	header := func(ts strfmt.DateTime) Header {
+		return Header{FlowID: m.FlowID, Seq: m.Seq, Time: time.Time(ts)}
+	}
+
+	switch {
+	case m.GraphCreated != nil:
+		e := m.GraphCreated
+		return &GraphCreated{Header: header(e.Ts), FunctionID: e.FunctionID}, nil
+
+	case m.GraphCommitted != nil:
+		return &GraphCommitted{Header: header(m.GraphCommitted.Ts)}, nil
+
+	case m.GraphTerminating != nil:
+		e := m.GraphTerminating
+		return &GraphTerminating{Header: header(e.Ts), FunctionID: e.FunctionID, Status: e.Status}, nil
+
+	case m.GraphCompleted != nil:
+		e := m.GraphCompleted
+		return &GraphCompleted{Header: header(e.Ts), FunctionID: e.FunctionID}, nil
+
+	case m.StageAdded != nil:
+		e := m.StageAdded
+		return &StageAdded{
+			Header:       header(e.Ts),
+			StageID:      e.StageID,
+			Op:           e.Op,
+			Dependencies: e.Dependencies,
+			Closure:      blobFromModel(m.FlowID, e.Closure),
+			CodeLocation: e.CodeLocation,
+			CallerID:     e.CallerID,
+		}, nil
+
+	case m.StageCompleted != nil:
+		e := m.StageCompleted
+		return &StageCompleted{Header: header(e.Ts), StageID: e.StageID, Result: ResultFromModel(m.FlowID, e.Result)}, nil
+
+	case m.StageComposed != nil:
+		e := m.StageComposed
+		return &StageComposed{Header: header(e.Ts), StageID: e.StageID, ComposedStageID: e.ComposedStageID}, nil
+
+	case m.DelayScheduled != nil:
+		e := m.DelayScheduled
+		return &DelayScheduled{Header: header(e.Ts), StageID: e.StageID, At: time.Unix(0, e.TimeMs*int64(time.Millisecond)).UTC()}, nil
+
+	case m.FaasInvocationStarted != nil:
+		e := m.FaasInvocationStarted
+		return &FunctionStarted{Header: header(e.Ts), StageID: e.StageID, FunctionID: e.FunctionID}, nil
+
+	case m.FaasInvocationCompleted != nil:
+		e := m.FaasInvocationCompleted
+		return &FunctionCompleted{Header: header(e.Ts), StageID: e.StageID, CallID: e.CallID, Result: ResultFromModel(m.FlowID, e.Result)}, nil
+	}
+	return nil, ErrUnknownEvent
+}
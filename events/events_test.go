@@ -0,0 +1,169 @@
+package events
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fnproject/flow-lib-go/models"
+	strfmt "github.com/go-openapi/strfmt"
+)
+
+var (
+	ts     = time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+	header = Header{FlowID: "flow", Seq: 3, Time: ts}
+	blob   = &models.ModelBlobDatum{BlobID: "b", ContentType: "application/json", Length: 2}
+)
+
+func TestFromModel(t *testing.T) {
+	dt := strfmt.DateTime(ts)
+	tests := []struct {
+		name  string
+		model models.ModelGraphEvent
+		want  Event
+	}{
+		{"graph created",
+			models.ModelGraphEvent{GraphCreated: &models.ModelGraphCreatedEvent{FunctionID: "app/fn", Ts: dt}},
+			&GraphCreated{Header: header, FunctionID: "app/fn"}},
+		{"graph committed",
+			models.ModelGraphEvent{GraphCommitted: &models.ModelGraphCommittedEvent{Ts: dt}},
+			&GraphCommitted{Header: header}},
+		{"graph terminating",
+			models.ModelGraphEvent{GraphTerminating: &models.ModelGraphTerminatingEvent{FunctionID: "app/fn", Status: models.ModelStatusDatumTypeFailed, Ts: dt}},
+			&GraphTerminating{Header: header, FunctionID: "app/fn", Status: models.ModelStatusDatumTypeFailed}},
+		{"graph completed",
+			models.ModelGraphEvent{GraphCompleted: &models.ModelGraphCompletedEvent{FunctionID: "app/fn", Ts: dt}},
+			&GraphCompleted{Header: header, FunctionID: "app/fn"}},
+		{"stage added",
+			models.ModelGraphEvent{StageAdded: &models.ModelStageAddedEvent{
+				StageID: "2", Op: models.ModelCompletionOperationThenApply, Dependencies: []string{"1"},
+				Closure: blob, CodeLocation: "main.go:12", CallerID: "1", Ts: dt}},
+			&StageAdded{Header: header, StageID: "2", Op: models.ModelCompletionOperationThenApply, Dependencies: []string{"1"},
+				Closure: &Blob{FlowID: "flow", BlobID: "b", ContentType: "application/json", Length: 2}, CodeLocation: "main.go:12", CallerID: "1"}},
+		{"stage completed",
+			models.ModelGraphEvent{StageCompleted: &models.ModelStageCompletedEvent{StageID: "2",
+				Result: &models.ModelCompletionResult{Datum: &models.ModelDatum{Error: &models.ModelErrorDatum{Type: models.ModelErrorDatumTypeStageTimeout, Message: "slow"}}}, Ts: dt}},
+			&StageCompleted{Header: header, StageID: "2", Result: Result{Datum: &Error{Type: models.ModelErrorDatumTypeStageTimeout, Message: "slow"}}}},
+		{"stage composed",
+			models.ModelGraphEvent{StageComposed: &models.ModelStageComposedEvent{StageID: "2", ComposedStageID: "4", Ts: dt}},
+			&StageComposed{Header: header, StageID: "2", ComposedStageID: "4"}},
+		{"delay scheduled",
+			models.ModelGraphEvent{DelayScheduled: &models.ModelDelayScheduledEvent{StageID: "2", TimeMs: ts.Add(time.Minute).UnixNano() / int64(time.Millisecond), Ts: dt}},
+			&DelayScheduled{Header: header, StageID: "2", At: ts.Add(time.Minute)}},
+		{"function started",
+			models.ModelGraphEvent{FaasInvocationStarted: &models.ModelFaasInvocationStartedEvent{StageID: "2", FunctionID: "app/other", Ts: dt}},
+			&FunctionStarted{Header: header, StageID: "2", FunctionID: "app/other"}},
+		{"function completed",
+			models.ModelGraphEvent{FaasInvocationCompleted: &models.ModelFaasInvocationCompletedEvent{StageID: "2", CallID: "call",
+				Result: &models.ModelCompletionResult{Successful: true, Datum: &models.ModelDatum{HTTPResp: &models.ModelHTTPRespDatum{
+					StatusCode: 201, Headers: []*models.ModelHTTPHeader{{Key: "X-Id", Value: "1"}}, Body: blob}}}, Ts: dt}},
+			&FunctionCompleted{Header: header, StageID: "2", CallID: "call", Result: Result{Successful: true, Datum: &HTTPResponse{
+				StatusCode: 201, Headers: http.Header{"X-Id": {"1"}}, Body: &Blob{FlowID: "flow", BlobID: "b", ContentType: "application/json", Length: 2}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.model.FlowID = "flow"
+			tt.model.Seq = 3
+			got, err := FromModel(&tt.model)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if got.EventHeader() != header {
+				t.Errorf("got header %+v, want %+v", got.EventHeader(), header)
+			}
+		})
+	}
+
+	if _, err := FromModel(&models.ModelGraphEvent{FlowID: "flow", Seq: 1}); err != ErrUnknownEvent {
+		t.Errorf("got %v for an event without a type, want ErrUnknownEvent", err)
+	}
+}
+
+func TestDecoderNext(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		seqs   []uint64
+		err    string
+	}{
+		{"enveloped",
+			`{"result":{"seq":1,"flow_id":"flow","graph_created":{"function_id":"app/fn"}}}` + "\n" +
+				`{"result":{"seq":2,"flow_id":"flow","graph_committed":{}}}` + "\n",
+			[]uint64{1, 2}, ""},
+		{"unwrapped",
+			`{"seq":1,"flow_id":"flow","graph_created":{"function_id":"app/fn"}}` + "\n" +
+				`{"seq":2,"flow_id":"flow","graph_completed":{}}`,
+			[]uint64{1, 2}, ""},
+		{"unknown event type skipped",
+			`{"result":{"seq":1,"flow_id":"flow","graph_renamed":{}}}` + "\n" +
+				`{"result":{"seq":2,"flow_id":"flow","graph_committed":{}}}` + "\n",
+			[]uint64{2}, ""},
+		{"error envelope",
+			`{"result":{"seq":1,"flow_id":"flow","graph_committed":{}}}` + "\n" +
+				`{"error":{"message":"flow not found"}}` + "\n",
+			[]uint64{1}, `Stream failed: {"message":"flow not found"}`},
+		{"invalid JSON",
+			`{"result":`,
+			nil, "unexpected EOF"},
+		{"empty", "", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tt.stream))
+			var seqs []uint64
+			var err error
+			for {
+				var ev Event
+				if ev, err = d.Next(); err != nil {
+					break
+				}
+				seqs = append(seqs, ev.EventHeader().Seq)
+			}
+			if !reflect.DeepEqual(seqs, tt.seqs) {
+				t.Errorf("got events %v, want %v", seqs, tt.seqs)
+			}
+			switch {
+			case tt.err == "" && err != io.EOF:
+				t.Errorf("got %v, want io.EOF", err)
+			case tt.err != "" && (err == nil || err.Error() != tt.err):
+				t.Errorf("got %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestReadAll(t *testing.T) {
+	stream := `{"result":{"seq":1,"flow_id":"flow","graph_created":{"function_id":"app/fn"}}}
+{"result":{"seq":2,"flow_id":"flow","stage_added":{"stage_id":"0","op":"supply","dependencies":[]}}}
+{"result":{"seq":3,"flow_id":"flow","stage_completed":{"stage_id":"0","result":{"successful":true,"datum":{"empty":{}}}}}}
+`
+	evs, err := ReadAll(strings.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 3 {
+		t.Fatalf("got %d events, want 3", len(evs))
+	}
+	if _, ok := evs[1].(*StageAdded); !ok {
+		t.Errorf("got %T, want a StageAdded", evs[1])
+	}
+	completed, ok := evs[2].(*StageCompleted)
+	if !ok || !completed.Result.Successful || !reflect.DeepEqual(completed.Result.Datum, &Empty{}) {
+		t.Errorf("got %+v, want a successful empty StageCompleted", evs[2])
+	}
+
+	evs, err = ReadAll(strings.NewReader(stream + `{"error":"gone"}`))
+	if err == nil || len(evs) != 3 {
+		t.Errorf("got %d events and %v, want the 3 events before the error", len(evs), err)
+	}
+	if errors.Is(err, io.EOF) {
+		t.Errorf("got %v, want the stream's error", err)
+	}
+}
@@ -0,0 +1,184 @@
+package events
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/fnproject/flow-lib-go/blobstore"
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+// Result is the result of a stage or function invocation
+type Result struct {
+	Successful bool
+	Datum      Datum // nil if the flow service didn't send a datum
+}
+
+func (r Result) String() string {
+	outcome := "failed"
+	if r.Successful {
+		outcome = "successful"
+	}
+	if r.Datum == nil {
+		return outcome
+	}
+	return fmt.Sprintf("%s: %v", outcome, r.Datum)
+}
+
+// Datum is one of the datum types of this package
+type Datum interface {
+	fmt.Stringer
+	isDatum()
+}
+
+// Empty is the result of stages without a value, such as thenAccept
+type Empty struct{}
+
+// Blob is a value stored in the blob store, such as one encoded by a
+// continuation
+type Blob struct {
+	FlowID      string // the blob's prefix in the blob store
+	BlobID      string
+	ContentType string
+	Length      int64
+}
+
+// Error is an error raised by the flow service, such as a stage timing out
+type Error struct {
+	Type    models.ModelErrorDatumType
+	Message string
+}
+
+// StageRef is returned by the continuation of a compose stage
+type StageRef struct {
+	StageID string
+}
+
+// HTTPRequest is the argument of an InvokeFunction stage
+type HTTPRequest struct {
+	Method  string
+	Headers http.Header
+	Body    *Blob
+}
+
+// HTTPResponse is the result of an InvokeFunction stage
+type HTTPResponse struct {
+	StatusCode int
+	Headers    http.Header
+	Body       *Blob
+}
+
+// Status is the status passed to termination hooks
+type Status struct {
+	Type models.ModelStatusDatumType
+}
+
+func (*Empty) isDatum()        {}
+func (*Blob) isDatum()         {}
+func (*Error) isDatum()        {}
+func (*StageRef) isDatum()     {}
+func (*HTTPRequest) isDatum()  {}
+func (*HTTPResponse) isDatum() {}
+func (*Status) isDatum()       {}
+
+func (*Empty) String() string {
+	return "empty"
+}
+
+func (b *Blob) String() string {
+	return fmt.Sprintf("blob %s (%s, %d bytes)", b.BlobID, b.ContentType, b.Length)
+}
+
+// Read returns the contents of the blob
+func (b *Blob) Read(store blobstore.BlobStoreClient) ([]byte, error) {
+	var data []byte
+	var readErr error
+	err := store.ReadBlob(b.FlowID, b.BlobID, b.ContentType, func(body io.ReadCloser) {
+		data, readErr = ioutil.ReadAll(body)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, readErr
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type, e.Message)
+}
+
+func (e *Error) String() string {
+	return "error " + e.Error()
+}
+
+func (r *StageRef) String() string {
+	return "stage " + r.StageID
+}
+
+func (r *HTTPRequest) String() string {
+	return fmt.Sprintf("http request %s", strings.ToUpper(r.Method))
+}
+
+func (r *HTTPResponse) String() string {
+	return fmt.Sprintf("http response %d", r.StatusCode)
+}
+
+func (s *Status) String() string {
+	return "status " + string(s.Type)
+}
+
+// ResultFromModel converts a result of the flow service API, such as that
+// of an awaited stage, for the flow with the given ID
+func ResultFromModel(flowID string, m *models.ModelCompletionResult) Result {
+	if m == nil {
+		return Result{}
+	}
+	return Result{Successful: m.Successful, Datum: datumFromModel(flowID, m.Datum)}
+}
+
+func datumFromModel(flowID string, m *models.ModelDatum) Datum {
+	switch {
+	case m == nil:
+		return nil
+	case m.Blob != nil:
+		return blobFromModel(flowID, m.Blob)
+	case m.Error != nil:
+		return &Error{Type: m.Error.Type, Message: m.Error.Message}
+	case m.StageRef != nil:
+		return &StageRef{StageID: m.StageRef.StageID}
+	case m.HTTPReq != nil:
+		return &HTTPRequest{
+			Method:  string(m.HTTPReq.Method),
+			Headers: headersFromModel(m.HTTPReq.Headers),
+			Body:    blobFromModel(flowID, m.HTTPReq.Body),
+		}
+	case m.HTTPResp != nil:
+		return &HTTPResponse{
+			StatusCode: int(m.HTTPResp.StatusCode),
+			Headers:    headersFromModel(m.HTTPResp.Headers),
+			Body:       blobFromModel(flowID, m.HTTPResp.Body),
+		}
+	case m.Status != nil:
+		return &Status{Type: m.Status.Type}
+	}
+	// the empty datum is an empty object, which can't be told apart from
+	// a datum of a type this package doesn't know
+	return &Empty{}
+}
+
+func blobFromModel(flowID string, m *models.ModelBlobDatum) *Blob {
+	if m == nil {
+		return nil
+	}
+	return &Blob{FlowID: flowID, BlobID: m.BlobID, ContentType: m.ContentType, Length: m.Length}
+}
+
+func headersFromModel(m []*models.ModelHTTPHeader) http.Header {
+	headers := make(http.Header)
+	for _, h := range m {
+		headers.Add(h.Key, h.Value)
+	}
+	return headers
+}
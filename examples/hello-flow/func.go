@@ -12,6 +12,7 @@ import (
 
 	fdk "github.com/fnproject/fdk-go"
 	flows "github.com/fnproject/flow-lib-go"
+	"github.com/fnproject/flow-lib-go/models"
 )
 
 func init() {
@@ -30,6 +31,7 @@ func init() {
 	flows.RegisterAction(TransformExternalRequest)
 	flows.RegisterAction(FailedFunc)
 	flows.RegisterAction(HandleFunc)
+	flows.RegisterAction(TerminationHook)
 }
 
 func main() {
@@ -40,15 +42,17 @@ func main() {
 	//fdk.Handle(composedExample())
 	//fdk.Handle(delayExample())
 	//fdk.Handle(invokeExample())
+	//fdk.Handle(invokeJSONExample())
 	//fdk.Handle(completeExample())
 	//fdk.Handle(anyOfExample())
 	//fdk.Handle(allOfExample())
+	//fdk.Handle(terminationHookExample())
 }
 
 func stringExample() fdk.Handler {
 	return flows.WithFlow(
 		fdk.HandlerFunc(func(ctx context.Context, r io.Reader, w io.Writer) {
-			cf := flows.CurrentFlow().CompletedValue("foo")
+			cf := flows.FromContext(ctx).CompletedValue("foo")
 			valueCh, errorCh := cf.ThenApply(strings.ToUpper).ThenApply(strings.ToLower).Get()
 			printResult(w, valueCh, errorCh)
 		}))
@@ -57,7 +61,7 @@ func stringExample() fdk.Handler {
 func errorValueExample() fdk.Handler {
 	return flows.WithFlow(
 		fdk.HandlerFunc(func(ctx context.Context, r io.Reader, w io.Writer) {
-			cf := flows.CurrentFlow().CompletedValue(errors.New("foo"))
+			cf := flows.FromContext(ctx).CompletedValue(errors.New("foo"))
 			valueCh, errorCh := cf.ThenApply(strings.ToUpper).ThenApply(strings.ToLower).Get()
 			printResult(w, valueCh, errorCh)
 		}))
@@ -79,7 +83,7 @@ func HandleFunc(arg string, err error) string {
 func errorFuncExample() fdk.Handler {
 	return flows.WithFlow(
 		fdk.HandlerFunc(func(ctx context.Context, r io.Reader, w io.Writer) {
-			cf := flows.CurrentFlow().CompletedValue("hello")
+			cf := flows.FromContext(ctx).CompletedValue("hello")
 			valueCh, errorCh := cf.ThenApply(FailedFunc).Handle(HandleFunc).Get()
 			printResult(w, valueCh, errorCh)
 		}))
@@ -97,7 +101,7 @@ func FooToUpper(f *foo) *foo {
 func structExample() fdk.Handler {
 	return flows.WithFlow(
 		fdk.HandlerFunc(func(ctx context.Context, r io.Reader, w io.Writer) {
-			cf := flows.CurrentFlow().CompletedValue(&foo{Name: "foo"})
+			cf := flows.FromContext(ctx).CompletedValue(&foo{Name: "foo"})
 			valueCh, errorCh := cf.ThenApply(FooToUpper).Get()
 			printResult(w, valueCh, errorCh)
 		}))
@@ -110,7 +114,7 @@ func EmptyFunc() string {
 func delayExample() fdk.Handler {
 	return flows.WithFlow(
 		fdk.HandlerFunc(func(ctx context.Context, r io.Reader, w io.Writer) {
-			cf := flows.CurrentFlow().Delay(5 * time.Second).ThenApply(EmptyFunc)
+			cf := flows.FromContext(ctx).Delay(5 * time.Second).ThenApply(EmptyFunc)
 			valueCh, errorCh := cf.Get()
 			printResult(w, valueCh, errorCh)
 		}))
@@ -134,7 +138,7 @@ func invokeExample() fdk.Handler {
 			req := &flows.HTTPRequest{Method: "POST", Body: greeting}
                         // TODO replace the ID below with the function ID of target function to invoke
                         // see https://github.com/fnproject/flow-lib-go/tree/master/examples/greeter/README.md
-			cf := flows.CurrentFlow().InvokeFunction("01CQV4NEGMNG8G00GZJ0000002", req)
+			cf := flows.FromContext(ctx).InvokeFunction("01CQV4NEGMNG8G00GZJ0000002", req)
 			valueCh, errorCh := cf.Get()
 
 			select {
@@ -154,11 +158,25 @@ func invokeExample() fdk.Handler {
 		}))
 }
 
+func invokeJSONExample() fdk.Handler {
+	return flows.WithFlow(
+		fdk.HandlerFunc(func(ctx context.Context, r io.Reader, w io.Writer) {
+			// TODO replace the ID below with the function ID of target function to invoke
+			greeting := flows.InvokeFunctionJSON[GreetingResponse](flows.FromContext(ctx), "01CQV4NEGMNG8G00GZJ0000002", GreetingRequest{Name: "Charles"})
+			gr, err := greeting.GetWithTimeout(time.Minute * 1)
+			if err != nil {
+				fmt.Fprintf(w, "Flow failed with error %v", err)
+				return
+			}
+			fmt.Fprintf(w, "Got payload %v", gr)
+		}))
+}
+
 func anyOfExample() fdk.Handler {
 	return flows.WithFlow(
 		fdk.HandlerFunc(func(ctx context.Context, r io.Reader, w io.Writer) {
 
-			cf := flows.CurrentFlow()
+			cf := flows.FromContext(ctx)
 			s1 := cf.CompletedValue("first")
 			s2 := cf.Delay(2 * time.Second).ThenRun(EmptyFunc)
 
@@ -172,7 +190,7 @@ func allOfExample() fdk.Handler {
 	return flows.WithFlow(
 		fdk.HandlerFunc(func(ctx context.Context, r io.Reader, w io.Writer) {
 
-			cf := flows.CurrentFlow()
+			cf := flows.FromContext(ctx)
 			s1 := cf.CompletedValue("first")
 			s2 := cf.Delay(2 * time.Second)
 
@@ -191,7 +209,7 @@ func TransformExternalRequest(req *flows.HTTPRequest) string {
 func completeExample() fdk.Handler {
 	return flows.WithFlow(
 		fdk.HandlerFunc(func(ctx context.Context, r io.Reader, w io.Writer) {
-			cs := flows.CurrentFlow().EmptyFuture()
+			cs := flows.FromContext(ctx).EmptyFuture()
 			cf := cs.ThenApply(strings.ToUpper)
 			cs.Complete("foo")
 			valueCh, errorCh := cf.Get()
@@ -199,19 +217,36 @@ func completeExample() fdk.Handler {
 		}))
 }
 
-func ComposedFunc(msg string) flows.FlowFuture {
-	return flows.CurrentFlow().CompletedValue("Hello " + msg)
+func ComposedFunc(ctx context.Context, msg string) flows.FlowFuture {
+	return flows.FromContext(ctx).CompletedValue("Hello " + msg)
 }
 
 func composedExample() fdk.Handler {
 	return flows.WithFlow(
 		fdk.HandlerFunc(func(ctx context.Context, r io.Reader, w io.Writer) {
-			cf := flows.CurrentFlow().CompletedValue("foo")
+			cf := flows.FromContext(ctx).CompletedValue("foo")
 			valueCh, errorCh := cf.ThenCompose(ComposedFunc).GetType(reflect.TypeOf(""))
 			printResult(w, valueCh, errorCh)
 		}))
 }
 
+func TerminationHook(status models.ModelStatusDatumType) {
+	flows.Log(fmt.Sprintf("Flow terminated with status %v", status))
+}
+
+func terminationHookExample() fdk.Handler {
+	return flows.WithFlow(
+		fdk.HandlerFunc(func(ctx context.Context, r io.Reader, w io.Writer) {
+			if err := flows.FromContext(ctx).AddTerminationHook(TerminationHook); err != nil {
+				fmt.Fprintf(w, "Failed to add termination hook %v", err)
+				return
+			}
+			cf := flows.FromContext(ctx).CompletedValue("foo")
+			valueCh, errorCh := cf.ThenApply(strings.ToUpper).Get()
+			printResult(w, valueCh, errorCh)
+		}))
+}
+
 func printResult(w io.Writer, valueCh chan interface{}, errorCh chan error) {
 	select {
 	case value := <-valueCh:
@@ -0,0 +1,93 @@
+package flow_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	fdk "github.com/fnproject/fdk-go"
+	flow "github.com/fnproject/flow-lib-go"
+	"github.com/fnproject/flow-lib-go/blobstore"
+	"github.com/fnproject/flow-lib-go/flowtest"
+)
+
+// externalResults receives the outcomes of the externally completed
+// futures of the flows under test
+var externalResults = make(chan string, 10)
+
+func recordExternal(s string, err error) string {
+	if err != nil {
+		s = "failed: " + err.Error()
+	}
+	externalResults <- s
+	return s
+}
+
+func init() {
+	if err := flow.RegisterAction(recordExternal); err != nil {
+		panic(err)
+	}
+}
+
+func TestCompleteExternally(t *testing.T) {
+	tests := []struct {
+		name     string
+		complete func(ctx context.Context, ref flow.StageRef) (bool, error)
+		want     string
+	}{
+		{"value", func(ctx context.Context, ref flow.StageRef) (bool, error) {
+			return flow.CompleteExternallyContext(ctx, ref, "approved")
+		}, "approved"},
+		{"error", func(ctx context.Context, ref flow.StageRef) (bool, error) {
+			return flow.FailExternallyContext(ctx, ref, errors.New("rejected"))
+		}, "failed: rejected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refs := make(chan flow.StageRef, 1)
+			h := flowtest.New(flow.WithFlow(fdk.HandlerFunc(func(ctx context.Context, in io.Reader, out io.Writer) {
+				f := flow.EmptyFuture[string](flow.FromContext(ctx))
+				flow.Handle(f, recordExternal)
+				refs <- f.Ref()
+			})))
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			inv := h.Invoke(ctx, strings.NewReader(""))
+
+			ref := <-refs
+			ok, err := tt.complete(h.Context(ctx), ref)
+			if err != nil || !ok {
+				t.Fatalf("got %v %v completing the stage, want true", ok, err)
+			}
+			if err := inv.Wait(ctx); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case s := <-externalResults:
+				if s != tt.want {
+					t.Errorf("got %q, want %q", s, tt.want)
+				}
+			default:
+				t.Fatal("the future's dependent stage didn't run")
+			}
+
+			ok, err = tt.complete(h.Context(ctx), ref)
+			if err != nil || ok {
+				t.Errorf("got %v %v completing the stage again, want false", ok, err)
+			}
+		})
+	}
+}
+
+func TestCompleteExternallyCancelled(t *testing.T) {
+	t.Setenv("COMPLETER_BASE_URL", "http://127.0.0.1:1")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ref := flow.StageRef{FlowID: "flow", StageID: "1"}
+	if _, err := flow.CompleteExternallyContext(ctx, ref, "approved", flow.WithBlobStore(blobstore.NewMemoryBlobStore())); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want the context's error", err)
+	}
+}
@@ -2,6 +2,7 @@ package flow
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,6 +14,7 @@ import (
 	"time"
 
 	fdk "github.com/fnproject/fdk-go"
+	"github.com/fnproject/flow-lib-go/blobstore"
 )
 
 // TODO take this off pkg level to get a handle on a flow ?
@@ -37,12 +39,29 @@ type Flow interface {
 	EmptyFuture() FlowFuture
 	AllOf(futures ...FlowFuture) FlowFuture
 	AnyOf(futures ...FlowFuture) FlowFuture
+	// AddTerminationHook registers an action that is run once the flow
+	// has terminated, however it ended. The action may take the final
+	// models.ModelStatusDatumType of the flow as its only argument; an
+	// error is returned for any other action.
+	AddTerminationHook(action interface{}) error
+	// State returns a snapshot of the stages of the flow
+	State(ctx context.Context) (*GraphState, error)
+	// Signal returns a future completed by SendSignal with the given name,
+	// which is the same future wherever in the flow it is called. ctx
+	// cancels looking up the signal's stage.
+	Signal(ctx context.Context, name string) FlowFuture
 }
 
 type FlowFuture interface {
 	Get() (chan interface{}, chan error)
 	// Get result as the given type. E.g. for use with ThenCompose
 	GetType(t reflect.Type) (chan interface{}, chan error)
+	// Await blocks until the result is available or ctx is done, in which
+	// case the pending request is cancelled. Returns ErrAwaitTimeout if
+	// the ctx deadline passes first.
+	Await(ctx context.Context) (interface{}, error)
+	// GetWithTimeout is equivalent to Await with a context bounded by d
+	GetWithTimeout(d time.Duration) (interface{}, error)
 	ThenApply(action interface{}) FlowFuture
 	ThenCompose(action interface{}) FlowFuture
 	ThenCombine(other FlowFuture, action interface{}) FlowFuture
@@ -56,6 +75,13 @@ type FlowFuture interface {
 	Exceptionally(action interface{}) FlowFuture
 	ExceptionallyCompose(action interface{}) FlowFuture
 	Complete(value interface{}) bool
+	// Ref returns a reference to the future's stage, e.g. for completing
+	// it from outside the flow with CompleteExternally
+	Ref() StageRef
+	// Err returns the error that prevented this future's stage from being
+	// added to the flow, if any. Futures derived from a failed future fail
+	// with the same error.
+	Err() error
 }
 
 var debugMu uint32 // atomics are faster than mu lock/unlock
@@ -90,24 +116,80 @@ func debug(msg string) {
 	}
 }
 
+// registered actions by key, including aliases
 var actions = make(map[string]interface{})
 
+// keys of registered actions by function pointer
+var actionKeys = make(map[uintptr]string)
+
+func funcPointer(actionFunc interface{}) uintptr {
+	return reflect.ValueOf(actionFunc).Pointer()
+}
+
+var errNotAction = errors.New("Action must be a function")
+
 func getActionKey(actionFunc interface{}) string {
-	return runtime.FuncForPC(reflect.ValueOf(actionFunc).Pointer()).Name()
+	if key, ok := actionKeys[funcPointer(actionFunc)]; ok {
+		return key
+	}
+	return runtime.FuncForPC(funcPointer(actionFunc)).Name()
 }
 
 // RegisterAction registers a go function so it can be used as an action
-// in a flow stage
-func RegisterAction(actionFunc interface{}) {
-	if reflect.TypeOf(actionFunc).Kind() != reflect.Func {
-		panic("Action must be a function!")
+// in a flow stage. The action's key is derived from its fully qualified
+// name, so renaming or moving the function breaks in-flight flows that
+// reference it; use RegisterActionAs for keys that are stable across deploys.
+// An error is returned if actionFunc isn't a function.
+func RegisterAction(actionFunc interface{}) error {
+	if reflect.TypeOf(actionFunc) == nil || reflect.TypeOf(actionFunc).Kind() != reflect.Func {
+		return errNotAction
 	}
-	actions[getActionKey(actionFunc)] = actionFunc
+	return RegisterActionAs(runtime.FuncForPC(funcPointer(actionFunc)).Name(), actionFunc)
+}
+
+// RegisterActionAs registers a go function as an action under an explicit
+// key. Each key may only be registered to one function, and each function
+// under one key; use RegisterActionAlias to keep old keys working.
+func RegisterActionAs(name string, actionFunc interface{}) error {
+	if reflect.TypeOf(actionFunc) == nil || reflect.TypeOf(actionFunc).Kind() != reflect.Func {
+		return errNotAction
+	}
+	if name == "" {
+		return fmt.Errorf("Action name must not be empty")
+	}
+	ptr := funcPointer(actionFunc)
+	if existing, ok := actions[name]; ok && funcPointer(existing) != ptr {
+		return fmt.Errorf("Action name %q is already registered to %s", name, runtime.FuncForPC(funcPointer(existing)).Name())
+	}
+	if key, ok := actionKeys[ptr]; ok && key != name {
+		return fmt.Errorf("Action %s is already registered as %q", runtime.FuncForPC(ptr).Name(), key)
+	}
+	actions[name] = actionFunc
+	actionKeys[ptr] = name
+	return nil
+}
+
+// RegisterActionAlias makes continuations referencing the alias key invoke
+// the action registered under name, e.g. to keep flows started before an
+// action was renamed working. New stages always reference name.
+func RegisterActionAlias(alias string, name string) error {
+	target, ok := actions[name]
+	if !ok {
+		return fmt.Errorf("Action name %q is not registered", name)
+	}
+	if existing, ok := actions[alias]; ok && funcPointer(existing) != funcPointer(target) {
+		return fmt.Errorf("Action name %q is already registered to %s", alias, runtime.FuncForPC(funcPointer(existing)).Name())
+	}
+	actions[alias] = target
+	return nil
 }
 
 var cfMtx = &sync.Mutex{}
 var cf *flow
 
+// CurrentFlow returns the most recently initialized flow. It is retained
+// for compatibility only: a function serving concurrent invocations must
+// use FromContext instead, since other invocations replace the current flow.
 func CurrentFlow() Flow {
 	cfMtx.Lock()
 	defer cfMtx.Unlock()
@@ -117,52 +199,129 @@ func CurrentFlow() Flow {
 	return cf
 }
 
-func WithFlow(fn fdk.Handler) fdk.Handler {
+type flowContextKey struct{}
+
+func newFlowContext(ctx context.Context, f *flow) context.Context {
+	return context.WithValue(ctx, flowContextKey{}, f)
+}
+
+// FromContext returns the flow of the invocation, given the context passed
+// to a WithFlow handler or to an action taking a leading context.Context
+func FromContext(ctx context.Context) Flow {
+	f, ok := ctx.Value(flowContextKey{}).(*flow)
+	if !ok {
+		panic("Tried accessing flow from a context without one")
+	}
+	return f
+}
+
+// FlowOption configures the flows of a WithFlow handler
+type FlowOption func(*flowOptions)
+
+type flowOptions struct {
+	contentType string
+	blobStore   blobstore.BlobStoreClient // nil for blobstore.GetBlobStore
+}
+
+// WithCodec encodes values using the codec registered for the content type,
+// unless a codec was selected for the value's type with UseCodecForType.
+// Values are encoded with gob by default.
+func WithCodec(contentType string) FlowOption {
+	return func(o *flowOptions) {
+		o.contentType = contentType
+	}
+}
+
+// WithBlobStore reads and writes the values, continuations and function
+// arguments of flows with the given blob store, rather than the one
+// returned by blobstore.GetBlobStore. It must be the store the flow
+// service reads the blobs from, or share its storage.
+func WithBlobStore(blobStore blobstore.BlobStoreClient) FlowOption {
+	return func(o *flowOptions) {
+		o.blobStore = blobStore
+	}
+}
+
+// WithFlow wraps the handler of a function using flows. Options apply both
+// to the handler's invocations and to the continuations invoked by its flows.
+func WithFlow(fn fdk.Handler, opts ...FlowOption) fdk.Handler {
+	options := &flowOptions{contentType: GobMediaHeader}
+	for _, opt := range opts {
+		opt(options)
+	}
 	return fdk.HandlerFunc(func(ctx context.Context, in io.Reader, out io.Writer) {
 		codec := newCodec(ctx, in, out)
 		if codec.isContinuation() {
-			initFlow(codec, false)
-			handleInvocation(codec)
+			f, err := initFlow(ctx, codec, options, false)
+			if err != nil {
+				failInvocation(out, err)
+				return
+			}
+			handleInvocation(newFlowContext(ctx, f), f, codec)
+			return
+		}
+		f, err := initFlow(ctx, codec, options, true)
+		if err != nil {
+			failInvocation(out, err)
 			return
 		}
-		initFlow(codec, true)
-		defer cf.commit()
+		defer f.commit()
 		debug("Invoking user's main flow function")
 		// TODO do we want separate reader/writer here?
-		fn.Serve(ctx, in, out)
+		fn.Serve(newFlowContext(ctx, f), in, out)
 		debug("Completed invocation of user's main flow function")
 	})
 }
 
-func initFlow(codec codec, shouldCreate bool) {
-	client := newFlowClient()
+func failInvocation(out io.Writer, err error) {
+	fmt.Fprintf(os.Stderr, "Failed to initialize flow: %v\n", err)
+	fdk.WriteStatus(out, http.StatusInternalServerError)
+	fmt.Fprintf(out, "Failed to initialize flow: %v", err)
+}
+
+func initFlow(ctx context.Context, codec codec, options *flowOptions, shouldCreate bool) (*flow, error) {
+	client, err := newFlowClient(ctx, options)
+	if err != nil {
+		return nil, err
+	}
 	var flowID string
 	if shouldCreate {
-		flowID = client.createFlow(codec.getFunctionID())
+		if flowID, err = client.createFlow(codec.getFunctionID()); err != nil {
+			return nil, err
+		}
 		debug(fmt.Sprintf("Created new flow %v", flowID))
 	} else {
 		flowID = codec.getFlowID()
 		debug(fmt.Sprintf("Awakened flow %v", flowID))
 	}
+	recordBlobStore(ctx, flowID, client.blobStore)
+	f := &flow{
+		client:    client,
+		blobStore: client.blobStore,
+		flowID:    flowID,
+		codec:     codec,
+		options:   options,
+	}
 	cfMtx.Lock()
 	defer cfMtx.Unlock()
-	cf = &flow{
-		client: client,
-		flowID: flowID,
-		codec:  codec,
-	}
+	cf = f
+	return f, nil
 }
 
 type flow struct {
-	client flowClient
-	flowID string
-	codec  codec
+	client    flowClient
+	blobStore blobstore.BlobStoreClient
+	flowID    string
+	codec     codec
+	options   *flowOptions
 }
 
 type flowFuture struct {
 	*flow
 	stageID    string
 	returnType reflect.Type
+	err        error  // set if the stage couldn't be added to the flow
+	functionID string // set if the stage invokes a function
 }
 
 // wraps result to runtime.Caller()
@@ -185,10 +344,15 @@ func newCodeLoc() *codeLoc {
 }
 
 func (cf *flow) commit() {
-	cf.client.commit(cf.flowID)
+	if err := cf.client.commit(cf.flowID); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
 }
 
 func returnTypeForFunc(fn interface{}) reflect.Type {
+	if b, ok := fn.(*boundAction); ok {
+		fn = b.action
+	}
 	t := reflect.ValueOf(fn).Type()
 	if t.NumOut() > 0 {
 		return t.Out(0)
@@ -196,37 +360,50 @@ func returnTypeForFunc(fn interface{}) reflect.Type {
 	return nil
 }
 
-func (cf *flow) continuationFuture(stageID string, fn interface{}) *flowFuture {
-	return &flowFuture{flow: cf, stageID: stageID, returnType: returnTypeForFunc(fn)}
+func (cf *flow) continuationFuture(stageID string, err error, fn interface{}) *flowFuture {
+	return &flowFuture{flow: cf, stageID: stageID, returnType: returnTypeForFunc(fn), err: err}
+}
+
+// failedFuture returns the first of the given futures whose stage couldn't
+// be added to the flow, or nil if none failed
+func failedFuture(futures ...FlowFuture) *flowFuture {
+	for _, f := range futures {
+		if ff, ok := f.(*flowFuture); ok && ff.err != nil {
+			return ff
+		}
+	}
+	return nil
 }
 
 func (cf *flow) Supply(action interface{}) FlowFuture {
-	sid := cf.client.supply(cf.flowID, action, newCodeLoc())
-	return cf.continuationFuture(sid, action)
+	sid, err := cf.client.supply(cf.flowID, action, newCodeLoc())
+	return cf.continuationFuture(sid, err, action)
 }
 
 func (cf *flow) Delay(duration time.Duration) FlowFuture {
-	sid := cf.client.delay(cf.flowID, duration, newCodeLoc())
-	return &flowFuture{flow: cf, stageID: sid}
+	sid, err := cf.client.delay(cf.flowID, duration, newCodeLoc())
+	return &flowFuture{flow: cf, stageID: sid, err: err}
 }
 
 func (cf *flow) CompletedValue(value interface{}) FlowFuture {
-	sid := cf.client.completedValue(cf.flowID, value, newCodeLoc())
-	return &flowFuture{flow: cf, stageID: sid, returnType: reflect.TypeOf(value)}
+	sid, err := cf.client.completedValue(cf.flowID, value, newCodeLoc())
+	return &flowFuture{flow: cf, stageID: sid, returnType: reflect.TypeOf(value), err: err}
 }
 
 func (cf *flow) InvokeFunction(functionID string, arg *HTTPRequest) FlowFuture {
-	sid := cf.client.invokeFunction(cf.flowID, functionID, arg, newCodeLoc())
+	sid, err := cf.client.invokeFunction(cf.flowID, functionID, arg, newCodeLoc())
 	return &flowFuture{
 		flow:       cf,
 		stageID:    sid,
 		returnType: reflect.TypeOf(new(HTTPResponse)),
+		err:        err,
+		functionID: functionID,
 	}
 }
 
 func (cf *flow) EmptyFuture() FlowFuture {
-	sid := cf.client.emptyFuture(cf.flowID, newCodeLoc())
-	return &flowFuture{flow: cf, stageID: sid}
+	sid, err := cf.client.emptyFuture(cf.flowID, newCodeLoc())
+	return &flowFuture{flow: cf, stageID: sid, err: err}
 }
 
 func futureCids(futures ...FlowFuture) []string {
@@ -239,12 +416,18 @@ func futureCids(futures ...FlowFuture) []string {
 }
 
 func (cf *flow) AllOf(futures ...FlowFuture) FlowFuture {
-	sid := cf.client.allOf(cf.flowID, futureCids(futures...), newCodeLoc())
-	return &flowFuture{flow: cf, stageID: sid}
+	if ff := failedFuture(futures...); ff != nil {
+		return ff
+	}
+	sid, err := cf.client.allOf(cf.flowID, futureCids(futures...), newCodeLoc())
+	return &flowFuture{flow: cf, stageID: sid, err: err}
 }
 
 func (cf *flow) AnyOf(futures ...FlowFuture) FlowFuture {
-	sid := cf.client.anyOf(cf.flowID, futureCids(futures...), newCodeLoc())
+	if ff := failedFuture(futures...); ff != nil {
+		return ff
+	}
+	sid, err := cf.client.anyOf(cf.flowID, futureCids(futures...), newCodeLoc())
 	// If all dependent futures are of the same type, we can introspect
 	// the type as a convenience. Otherwise, we have no way of determining
 	// the return type at runtime
@@ -270,79 +453,172 @@ func (cf *flow) AnyOf(futures ...FlowFuture) FlowFuture {
 		flow:       cf,
 		stageID:    sid,
 		returnType: introspected,
+		err:        err,
+	}
+}
+
+func (cf *flow) AddTerminationHook(action interface{}) error {
+	fn := unbound(action)
+	if fn == nil || reflect.TypeOf(fn).Kind() != reflect.Func {
+		return errors.New("Termination hook must be a function")
+	}
+	bound := 0
+	if b, ok := action.(*boundAction); ok {
+		bound = len(b.args)
+	}
+	if len(actionParams(reflect.TypeOf(fn)))-bound > 1 {
+		return errors.New("Termination hook must be a function taking at most one argument")
+	}
+	return cf.client.addTerminationHook(cf.flowID, action, newCodeLoc())
+}
+
+func (f *flowFuture) Err() error {
+	return f.err
+}
+
+func (f *flowFuture) getAsync(ctx context.Context, rType reflect.Type) (chan interface{}, chan error) {
+	if f.err != nil {
+		valueCh := make(chan interface{}, 1)
+		errorCh := make(chan error, 1)
+		errorCh <- f.err
+		return valueCh, errorCh
 	}
+	return f.client.getAsync(ctx, f, rType)
 }
 
 func (f *flowFuture) Get() (chan interface{}, chan error) {
-	return f.client.getAsync(f.flowID, f.stageID, f.returnType)
+	return f.getAsync(context.Background(), f.returnType)
 }
 
 func (f *flowFuture) GetType(t reflect.Type) (chan interface{}, chan error) {
-	return f.client.getAsync(f.flowID, f.stageID, t)
+	return f.getAsync(context.Background(), t)
+}
+
+func (f *flowFuture) Await(ctx context.Context) (interface{}, error) {
+	valueCh, errorCh := f.getAsync(ctx, f.returnType)
+	select {
+	case v := <-valueCh:
+		return v, nil
+	case err := <-errorCh:
+		return nil, err
+	}
+}
+
+func (f *flowFuture) GetWithTimeout(d time.Duration) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return f.Await(ctx)
 }
 
 func (f *flowFuture) ThenApply(action interface{}) FlowFuture {
-	sid := f.client.thenApply(f.flowID, f.stageID, action, newCodeLoc())
-	return cf.continuationFuture(sid, action)
+	if ff := failedFuture(f); ff != nil {
+		return ff
+	}
+	sid, err := f.client.thenApply(f.flowID, f.stageID, action, newCodeLoc())
+	return f.continuationFuture(sid, err, action)
 }
 
 func (f *flowFuture) ThenCompose(action interface{}) FlowFuture {
-	sid := f.client.thenCompose(f.flowID, f.stageID, action, newCodeLoc())
+	if ff := failedFuture(f); ff != nil {
+		return ff
+	}
+	sid, err := f.client.thenCompose(f.flowID, f.stageID, action, newCodeLoc())
 	// no type information available for inner future
-	return &flowFuture{flow: cf, stageID: sid}
+	return &flowFuture{flow: f.flow, stageID: sid, err: err}
 }
 
 func (f *flowFuture) ThenCombine(other FlowFuture, action interface{}) FlowFuture {
-	sid := f.client.thenCombine(f.flowID, f.stageID, other.(*flowFuture).stageID, action, newCodeLoc())
-	return cf.continuationFuture(sid, action)
+	if ff := failedFuture(f, other); ff != nil {
+		return ff
+	}
+	sid, err := f.client.thenCombine(f.flowID, f.stageID, other.(*flowFuture).stageID, action, newCodeLoc())
+	return f.continuationFuture(sid, err, action)
 }
 
 func (f *flowFuture) WhenComplete(action interface{}) FlowFuture {
-	sid := f.client.whenComplete(f.flowID, f.stageID, action, newCodeLoc())
-	return cf.continuationFuture(sid, action)
+	if ff := failedFuture(f); ff != nil {
+		return ff
+	}
+	sid, err := f.client.whenComplete(f.flowID, f.stageID, action, newCodeLoc())
+	// whenComplete stages pass on the result of the stage they follow
+	return &flowFuture{flow: f.flow, stageID: sid, returnType: f.returnType, err: err}
 }
 
 func (f *flowFuture) ThenAccept(action interface{}) FlowFuture {
-	sid := f.client.thenAccept(f.flowID, f.stageID, action, newCodeLoc())
-	return cf.continuationFuture(sid, action)
+	if ff := failedFuture(f); ff != nil {
+		return ff
+	}
+	sid, err := f.client.thenAccept(f.flowID, f.stageID, action, newCodeLoc())
+	return f.continuationFuture(sid, err, action)
 }
 
 func (f *flowFuture) AcceptEither(other FlowFuture, action interface{}) FlowFuture {
-	sid := f.client.acceptEither(f.flowID, f.stageID, other.(*flowFuture).stageID, action, newCodeLoc())
-	return cf.continuationFuture(sid, action)
+	if ff := failedFuture(f, other); ff != nil {
+		return ff
+	}
+	sid, err := f.client.acceptEither(f.flowID, f.stageID, other.(*flowFuture).stageID, action, newCodeLoc())
+	return f.continuationFuture(sid, err, action)
 }
 
 func (f *flowFuture) ApplyToEither(other FlowFuture, action interface{}) FlowFuture {
-	sid := f.client.applyToEither(f.flowID, f.stageID, other.(*flowFuture).stageID, action, newCodeLoc())
-	return cf.continuationFuture(sid, action)
+	if ff := failedFuture(f, other); ff != nil {
+		return ff
+	}
+	sid, err := f.client.applyToEither(f.flowID, f.stageID, other.(*flowFuture).stageID, action, newCodeLoc())
+	return f.continuationFuture(sid, err, action)
 }
 
 func (f *flowFuture) ThenAcceptBoth(other FlowFuture, action interface{}) FlowFuture {
-	sid := f.client.thenAcceptBoth(f.flowID, f.stageID, other.(*flowFuture).stageID, action, newCodeLoc())
-	return cf.continuationFuture(sid, action)
+	if ff := failedFuture(f, other); ff != nil {
+		return ff
+	}
+	sid, err := f.client.thenAcceptBoth(f.flowID, f.stageID, other.(*flowFuture).stageID, action, newCodeLoc())
+	return f.continuationFuture(sid, err, action)
 }
 
 func (f *flowFuture) ThenRun(action interface{}) FlowFuture {
-	sid := f.client.thenRun(f.flowID, f.stageID, action, newCodeLoc())
-	return cf.continuationFuture(sid, action)
+	if ff := failedFuture(f); ff != nil {
+		return ff
+	}
+	sid, err := f.client.thenRun(f.flowID, f.stageID, action, newCodeLoc())
+	return f.continuationFuture(sid, err, action)
 }
 
 func (f *flowFuture) Handle(action interface{}) FlowFuture {
-	sid := f.client.handle(f.flowID, f.stageID, action, newCodeLoc())
-	return cf.continuationFuture(sid, action)
+	if ff := failedFuture(f); ff != nil {
+		return ff
+	}
+	sid, err := f.client.handle(f.flowID, f.stageID, action, newCodeLoc())
+	return f.continuationFuture(sid, err, action)
 }
 
 func (f *flowFuture) Exceptionally(action interface{}) FlowFuture {
-	sid := f.client.exceptionally(f.flowID, f.stageID, action, newCodeLoc())
-	return cf.continuationFuture(sid, action)
+	if ff := failedFuture(f); ff != nil {
+		return ff
+	}
+	sid, err := f.client.exceptionally(f.flowID, f.stageID, action, newCodeLoc())
+	return f.continuationFuture(sid, err, action)
 }
 
 func (f *flowFuture) ExceptionallyCompose(action interface{}) FlowFuture {
-	sid := f.client.exceptionallyCompose(f.flowID, f.stageID, action, newCodeLoc())
-	// no type information available for inner future
-	return &flowFuture{flow: cf, stageID: sid}
+	if ff := failedFuture(f); ff != nil {
+		return ff
+	}
+	sid, err := f.client.exceptionallyCompose(f.flowID, f.stageID, action, newCodeLoc())
+	// the inner future replaces a failure of this one, so the type is retained
+	return &flowFuture{flow: f.flow, stageID: sid, returnType: f.returnType, err: err}
 }
 
+// Complete returns false if the stage was already completed or the
+// completion couldn't be delivered to the flow service
 func (f *flowFuture) Complete(value interface{}) bool {
-	return f.client.complete(f.flowID, f.stageID, value, newCodeLoc())
+	if f.err != nil {
+		return false
+	}
+	ok, err := f.client.complete(context.Background(), f.flowID, f.stageID, value, newCodeLoc())
+	if err != nil {
+		debug(fmt.Sprintf("Failed to complete stage: %v", err))
+		return false
+	}
+	return ok
 }
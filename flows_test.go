@@ -0,0 +1,69 @@
+package flow
+
+import (
+	"testing"
+
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+func TestAddTerminationHookRejectsInvalidActions(t *testing.T) {
+	// the client is never used, as the hooks are rejected first
+	cf := &flow{flowID: "flow"}
+	tests := []struct {
+		name   string
+		action interface{}
+	}{
+		{"nil", nil},
+		{"not a function", "hook"},
+		{"two arguments", func(models.ModelStatusDatumType, string) {}},
+		{"two bound arguments", Bind(func(string, models.ModelStatusDatumType, string) {}, "a")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := cf.AddTerminationHook(tt.action); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func registeredAction(s string) string {
+	return s
+}
+
+func otherRegisteredAction(s string) string {
+	return s
+}
+
+func TestRegisterActionRejectsNonFunctions(t *testing.T) {
+	for _, action := range []interface{}{nil, "action", 42} {
+		if err := RegisterAction(action); err == nil {
+			t.Errorf("got no error registering %v", action)
+		}
+		if err := RegisterActionAs("test-not-a-function", action); err == nil {
+			t.Errorf("got no error registering %v as an action", action)
+		}
+	}
+}
+
+func TestRegisterActionAs(t *testing.T) {
+	if err := RegisterActionAs("test-registered", registeredAction); err != nil {
+		t.Fatal(err)
+	}
+	// registering again under the same key is allowed
+	if err := RegisterActionAs("test-registered", registeredAction); err != nil {
+		t.Error(err)
+	}
+	if err := RegisterActionAs("test-registered", otherRegisteredAction); err == nil {
+		t.Error("got no error registering another action under the same key")
+	}
+	if err := RegisterActionAs("test-renamed", registeredAction); err == nil {
+		t.Error("got no error registering the action under another key")
+	}
+	if err := RegisterActionAs("", otherRegisteredAction); err == nil {
+		t.Error("got no error registering an action with an empty key")
+	}
+	if got := getActionKey(registeredAction); got != "test-registered" {
+		t.Errorf("got key %q, want test-registered", got)
+	}
+}
@@ -0,0 +1,276 @@
+// Package flowtest runs flow functions in-process against an in-memory
+// flow service and blob store, so WithFlow handlers can be tested with
+// go test. Continuations are executed by the handler under test using the
+// actions registered in the test binary.
+//
+//	h := flowtest.New(flow.WithFlow(fdk.HandlerFunc(myFunc)))
+//	h.Stub("myapp/other", func(req *flow.HTTPRequest) (*flow.HTTPResponse, error) {
+//		return &flow.HTTPResponse{StatusCode: 200, Body: []byte("hello")}, nil
+//	})
+//	inv := h.Invoke(ctx, strings.NewReader("input"))
+//	err := inv.Wait(ctx)
+package flowtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	fdk "github.com/fnproject/fdk-go"
+	flow "github.com/fnproject/flow-lib-go"
+	"github.com/fnproject/flow-lib-go/blobstore"
+	"github.com/fnproject/flow-lib-go/internal/engine"
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+// DefaultFunctionID is the ID of the function under test, unless set with
+// WithFunctionID
+const DefaultFunctionID = "flowtest/function"
+
+// Stub responds to invocations of a function from InvokeFunction stages.
+// An error fails the stage as if the function couldn't be invoked.
+type Stub func(req *flow.HTTPRequest) (*flow.HTTPResponse, error)
+
+// Harness runs a flow function and the continuations of the flows it
+// creates in-process
+type Harness struct {
+	handler    fdk.Handler
+	functionID string
+	clock      *Clock
+	blobStore  *blobstore.MemoryBlobStore
+	blobStores sync.Map // of flow IDs to the blob stores their flows use
+	engine     *engine.Engine
+
+	mu    sync.Mutex
+	stubs map[string]Stub
+}
+
+type Option func(*Harness)
+
+// WithFunctionID sets the ID of the function under test
+func WithFunctionID(functionID string) Option {
+	return func(h *Harness) {
+		h.functionID = functionID
+	}
+}
+
+// New returns a harness for the given handler, typically wrapped with
+// flow.WithFlow
+func New(handler fdk.Handler, opts ...Option) *Harness {
+	h := &Harness{
+		handler:    handler,
+		functionID: DefaultFunctionID,
+		clock:      NewClock(time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)),
+		blobStore:  blobstore.NewMemoryBlobStore(),
+		stubs:      make(map[string]Stub),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.engine = engine.New(invoker{h}, h.clock)
+	return h
+}
+
+// Clock returns the virtual clock of Delay stages
+func (h *Harness) Clock() *Clock {
+	return h.clock
+}
+
+// BlobStore returns the blob store used by flows, which is the one
+// selected with blobstore.SetBlobStore if any, or else in-memory. Flows of
+// handlers given flow.WithBlobStore use that store instead.
+func (h *Harness) BlobStore() blobstore.BlobStoreClient {
+	if selected := blobstore.SelectedBlobStore(); selected != nil {
+		return selected
+	}
+	return h.blobStore
+}
+
+// flowBlobStore returns the blob store used by the given flow
+func (h *Harness) flowBlobStore(flowID string) blobstore.BlobStoreClient {
+	if store, ok := h.blobStores.Load(flowID); ok {
+		return store.(blobstore.BlobStoreClient)
+	}
+	return h.BlobStore()
+}
+
+// service returns a flow service over the harness's engine
+func (h *Harness) service() *service {
+	return &service{engine: h.engine, blobStores: &h.blobStores}
+}
+
+// Context returns ctx with the harness's flow service and blob store, for
+// calling functions such as flow.SendSignalContext or flow.InspectContext
+// on the flows under test
+func (h *Harness) Context(ctx context.Context) context.Context {
+	return flow.WithServices(ctx, h.service(), h.blobStore)
+}
+
+// Stub sets the responses of the given function to InvokeFunction stages.
+// Invoking a function that isn't stubbed fails the stage.
+func (h *Harness) Stub(functionID string, stub Stub) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stubs[functionID] = stub
+}
+
+// Invocation is an invocation of the function under test
+type Invocation struct {
+	// FlowID is the ID of the flow created by the invocation, if any
+	FlowID string
+	// Response records the response of the function
+	Response *httptest.ResponseRecorder
+
+	h *Harness
+}
+
+// Invoke invokes the function under test with the given body, returning
+// once the handler has returned and its flow has been committed
+func (h *Harness) Invoke(ctx context.Context, body io.Reader) *Invocation {
+	inv := &Invocation{Response: httptest.NewRecorder(), h: h}
+	svc := h.service()
+	svc.onCreated = func(flowID string) { inv.FlowID = flowID }
+	ctx = flow.WithServices(ctx, svc, h.blobStore)
+	ctx = fdk.WithContext(ctx, h.fnContext(make(http.Header)))
+	h.handler.Serve(ctx, body, inv.Response)
+	return inv
+}
+
+// Wait blocks until the invocation's flow has terminated and its
+// termination hooks have run, or ctx is done
+func (inv *Invocation) Wait(ctx context.Context) error {
+	if inv.FlowID == "" {
+		return errors.New("Invocation didn't create a flow")
+	}
+	terminated, err := inv.h.engine.Terminated(inv.FlowID)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-terminated:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *Harness) fnContext(header http.Header) fdk.Context {
+	return &fnContext{
+		header: header,
+		config: map[string]string{
+			"FN_APP_ID": "flowtest",
+			"FN_FN_ID":  h.functionID,
+		},
+	}
+}
+
+// invoker runs the stages of the harness's flows
+type invoker struct {
+	*Harness
+}
+
+// InvokeStage invokes the handler under test with a continuation request,
+// as the flow service would
+func (h invoker) InvokeStage(flowID string, functionID string, stageID string, closure *models.ModelBlobDatum, args []*models.ModelCompletionResult) (result *models.ModelCompletionResult, err error) {
+	req := &flow.InvokeStageRequest{FlowID: flowID, StageID: stageID, Closure: closure, Args: args}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make(http.Header)
+	header.Set(flow.FlowIDHeader, flowID)
+	header.Set(flow.StageIDHeader, stageID)
+	header.Set(flow.ContentTypeHeader, flow.JSONMediaHeader)
+	ctx := flow.WithServices(context.Background(), h.service(), h.blobStore)
+	ctx = fdk.WithContext(ctx, h.fnContext(header))
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Continuation invocation panicked: %v", r)
+		}
+	}()
+	resp := httptest.NewRecorder()
+	h.handler.Serve(ctx, bytes.NewReader(body), resp)
+	if resp.Code != http.StatusOK {
+		return nil, fmt.Errorf("Continuation invocation failed with status %d: %s", resp.Code, resp.Body)
+	}
+
+	var out flow.InvokeStageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("Failed to decode continuation response: %v", err)
+	}
+	return out.Result, nil
+}
+
+// InvokeFunction responds to an InvokeFunction stage using the function's
+// stub, exchanging bodies through the blob store of the invoking flow
+func (h invoker) InvokeFunction(flowID string, functionID string, arg *models.ModelHTTPReqDatum) (*models.ModelHTTPRespDatum, error) {
+	h.mu.Lock()
+	stub, ok := h.stubs[functionID]
+	h.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("Function %s is not stubbed", functionID)
+	}
+	store := h.flowBlobStore(flowID)
+
+	req := &flow.HTTPRequest{Headers: make(http.Header), Method: strings.ToUpper(string(arg.Method))}
+	for _, header := range arg.Headers {
+		req.Headers.Add(header.Key, header.Value)
+	}
+	if arg.Body != nil {
+		var buf bytes.Buffer
+		var readErr error
+		err := store.ReadBlob(flowID, arg.Body.BlobID, arg.Body.ContentType, func(b io.ReadCloser) { _, readErr = buf.ReadFrom(b) })
+		if err == nil {
+			err = readErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		req.Body = buf.Bytes()
+	}
+
+	resp, err := stub(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, fmt.Errorf("Stub of function %s returned no response", functionID)
+	}
+	contentType := resp.Headers.Get(flow.ContentTypeHeader)
+	if contentType == "" {
+		contentType = flow.OctetStreamMediaHeader
+	}
+	b, err := store.WriteBlob(flowID, contentType, bytes.NewReader(resp.Body))
+	if err != nil {
+		return nil, err
+	}
+	datum := &models.ModelHTTPRespDatum{Body: b.BlobDatum(), StatusCode: resp.StatusCode}
+	for key, values := range resp.Headers {
+		for _, value := range values {
+			datum.Headers = append(datum.Headers, &models.ModelHTTPHeader{Key: key, Value: value})
+		}
+	}
+	return datum, nil
+}
+
+type fnContext struct {
+	header http.Header
+	config map[string]string
+}
+
+func (c *fnContext) Config() map[string]string { return c.config }
+func (c *fnContext) Header() http.Header       { return c.header }
+func (c *fnContext) ContentType() string       { return c.header.Get(flow.ContentTypeHeader) }
+func (c *fnContext) CallID() string            { return "" }
+func (c *fnContext) AppID() string             { return c.config["FN_APP_ID"] }
+func (c *fnContext) FnID() string              { return c.config["FN_FN_ID"] }
@@ -0,0 +1,276 @@
+package flowtest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	fdk "github.com/fnproject/fdk-go"
+	flow "github.com/fnproject/flow-lib-go"
+	"github.com/fnproject/flow-lib-go/blobstore"
+	"github.com/fnproject/flow-lib-go/flowtest"
+)
+
+// results and failures receive what the actions of the flows under test
+// see, as actions can't be closures
+var (
+	results  = make(chan string, 100)
+	failures = make(chan error, 100)
+)
+
+func greet(name string) string {
+	return "hello " + name
+}
+
+func recordResult(s string) {
+	results <- s
+}
+
+func recordInput(input string, s string) {
+	results <- input + ":" + s
+}
+
+func recordResponse(resp *flow.HTTPResponse) {
+	results <- fmt.Sprintf("%d %s", resp.StatusCode, resp.Body)
+}
+
+func recordFailure(err error) string {
+	failures <- err
+	return ""
+}
+
+func recordDelay() {
+	results <- "delayed"
+}
+
+func init() {
+	for _, action := range []interface{}{greet, strings.ToUpper, recordResult, recordInput, recordResponse, recordFailure, recordDelay} {
+		if err := flow.RegisterAction(action); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// handler returns a flow function running build with the flow and the
+// request body
+func handler(build func(fl flow.Flow, input string), opts ...flow.FlowOption) fdk.Handler {
+	return flow.WithFlow(fdk.HandlerFunc(func(ctx context.Context, in io.Reader, out io.Writer) {
+		input, _ := ioutil.ReadAll(in)
+		build(flow.FromContext(ctx), string(input))
+	}), opts...)
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func invokeAndWait(t *testing.T, h *flowtest.Harness, input string) *flowtest.Invocation {
+	t.Helper()
+	ctx := testContext(t)
+	inv := h.Invoke(ctx, strings.NewReader(input))
+	if err := inv.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return inv
+}
+
+// received returns the next result, failing the test if there is none
+func received(t *testing.T) string {
+	t.Helper()
+	select {
+	case s := <-results:
+		return s
+	default:
+		t.Fatal("got no result")
+		return ""
+	}
+}
+
+func TestInvokeWait(t *testing.T) {
+	h := flowtest.New(handler(func(fl flow.Flow, input string) {
+		fl.Supply(flow.Bind(greet, input)).ThenAccept(recordResult)
+	}))
+	inv := invokeAndWait(t, h, "world")
+
+	if inv.FlowID == "" {
+		t.Error("got no flow ID")
+	}
+	if inv.Response.Code != 200 {
+		t.Errorf("got status %d, want 200", inv.Response.Code)
+	}
+	if got := received(t); got != "hello world" {
+		t.Errorf("got %q, want %q", got, "hello world")
+	}
+}
+
+func TestWaitWithoutFlow(t *testing.T) {
+	h := flowtest.New(fdk.HandlerFunc(func(ctx context.Context, in io.Reader, out io.Writer) {}))
+	ctx := testContext(t)
+	if err := h.Invoke(ctx, strings.NewReader("")).Wait(ctx); err == nil {
+		t.Error("got no error waiting for an invocation without a flow")
+	}
+}
+
+func TestStub(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	h := flowtest.New(handler(func(fl flow.Flow, input string) {
+		req := &flow.HTTPRequest{Method: "POST", Body: []byte(input)}
+		fl.InvokeFunction("app/other", req).ThenAccept(recordResponse)
+	}))
+	h.Stub("app/other", func(req *flow.HTTPRequest) (*flow.HTTPResponse, error) {
+		mu.Lock()
+		requests = append(requests, req.Method+" "+string(req.Body))
+		mu.Unlock()
+		return &flow.HTTPResponse{StatusCode: 201, Body: []byte("pong")}, nil
+	})
+	invokeAndWait(t, h, "ping")
+
+	if got := received(t); got != "201 pong" {
+		t.Errorf("got response %q, want %q", got, "201 pong")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 || requests[0] != "POST ping" {
+		t.Errorf("got requests %q, want [POST ping]", requests)
+	}
+}
+
+func TestStubSelectedBlobStore(t *testing.T) {
+	store := blobstore.NewMemoryBlobStore()
+	blobstore.SetBlobStore(store)
+	defer blobstore.SetBlobStore(nil)
+
+	h := flowtest.New(handler(func(fl flow.Flow, input string) {
+		fl.InvokeFunction("app/other", &flow.HTTPRequest{Method: "POST", Body: []byte(input)}).ThenAccept(recordResponse)
+	}))
+	h.Stub("app/other", func(req *flow.HTTPRequest) (*flow.HTTPResponse, error) {
+		return &flow.HTTPResponse{StatusCode: 200, Body: req.Body}, nil
+	})
+	if h.BlobStore() != store {
+		t.Error("the harness doesn't use the selected blob store")
+	}
+	invokeAndWait(t, h, "echo")
+
+	if got := received(t); got != "200 echo" {
+		t.Errorf("got response %q, want %q", got, "200 echo")
+	}
+}
+
+func TestStubFlowBlobStore(t *testing.T) {
+	store := blobstore.NewMemoryBlobStore()
+	h := flowtest.New(handler(func(fl flow.Flow, input string) {
+		fl.InvokeFunction("app/other", &flow.HTTPRequest{Method: "POST", Body: []byte(input)}).ThenAccept(recordResponse)
+	}, flow.WithBlobStore(store)))
+	h.Stub("app/other", func(req *flow.HTTPRequest) (*flow.HTTPResponse, error) {
+		return &flow.HTTPResponse{StatusCode: 200, Body: req.Body}, nil
+	})
+	invokeAndWait(t, h, "echo")
+
+	// the request and response bodies are only found in the flow's store
+	if got := received(t); got != "200 echo" {
+		t.Errorf("got response %q, want %q", got, "200 echo")
+	}
+}
+
+func TestStubMiss(t *testing.T) {
+	h := flowtest.New(handler(func(fl flow.Flow, input string) {
+		fl.InvokeFunction("app/missing", &flow.HTTPRequest{Method: "POST"}).Exceptionally(recordFailure)
+	}))
+	h.Stub("app/other", func(req *flow.HTTPRequest) (*flow.HTTPResponse, error) {
+		t.Error("invoked the stub of another function")
+		return nil, errors.New("wrong function")
+	})
+	invokeAndWait(t, h, "")
+
+	select {
+	case err := <-failures:
+		if !errors.Is(err, flow.ErrFunctionInvokeFailed) {
+			t.Errorf("got %v, want ErrFunctionInvokeFailed", err)
+		}
+	default:
+		t.Fatal("invoking an unstubbed function didn't fail")
+	}
+}
+
+func TestClockAdvanceCompletesDelay(t *testing.T) {
+	h := flowtest.New(handler(func(fl flow.Flow, input string) {
+		fl.Delay(time.Minute).ThenRun(recordDelay)
+	}))
+	ctx := testContext(t)
+	inv := h.Invoke(ctx, strings.NewReader(""))
+
+	if got := h.Clock().Pending(); got != 1 {
+		t.Fatalf("got %d pending delays, want 1", got)
+	}
+	h.Clock().Advance(59 * time.Second)
+	if got := h.Clock().Pending(); got != 1 {
+		t.Fatalf("got %d pending delays before the delay is due, want 1", got)
+	}
+	select {
+	case s := <-results:
+		t.Fatalf("got %q before the delay was due", s)
+	default:
+	}
+
+	h.Clock().Advance(time.Second)
+	if err := inv.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.Clock().Pending(); got != 0 {
+		t.Errorf("got %d pending delays once due, want 0", got)
+	}
+	if got := received(t); got != "delayed" {
+		t.Errorf("got %q, want %q", got, "delayed")
+	}
+}
+
+func TestParallelInvocations(t *testing.T) {
+	h := flowtest.New(handler(func(fl flow.Flow, input string) {
+		fl.CompletedValue(input).ThenApply(strings.ToUpper).ThenAccept(flow.Bind(recordInput, input))
+	}))
+
+	const n = 10
+	ctx := testContext(t)
+	invs := make([]*flowtest.Invocation, n)
+	var wg sync.WaitGroup
+	for i := range invs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			invs[i] = h.Invoke(ctx, strings.NewReader(fmt.Sprintf("input-%d", i)))
+		}(i)
+	}
+	wg.Wait()
+
+	flows := make(map[string]bool)
+	for _, inv := range invs {
+		if err := inv.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if flows[inv.FlowID] {
+			t.Errorf("flow %s was created by more than one invocation", inv.FlowID)
+		}
+		flows[inv.FlowID] = true
+	}
+
+	want := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		want[fmt.Sprintf("input-%d:INPUT-%d", i, i)] = true
+	}
+	for i := 0; i < n; i++ {
+		got := received(t)
+		if !want[got] {
+			t.Errorf("got unexpected or repeated result %q", got)
+		}
+		delete(want, got)
+	}
+}
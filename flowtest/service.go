@@ -0,0 +1,139 @@
+package flowtest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fnproject/flow-lib-go/blobstore"
+	flowSvc "github.com/fnproject/flow-lib-go/client/flow_service"
+	"github.com/fnproject/flow-lib-go/internal/engine"
+	"github.com/fnproject/flow-lib-go/models"
+	"github.com/go-openapi/runtime"
+)
+
+// service implements flow.FlowService over the harness's engine, failing
+// with the same API errors as the generated client
+type service struct {
+	engine     *engine.Engine
+	blobStores *sync.Map // of flow IDs to the blob stores their flows use
+	onCreated  func(flowID string)
+}
+
+// RecordBlobStore implements flow.BlobStoreRecorder, so that stubs of
+// functions read and write blobs with the store of the invoking flow
+func (s *service) RecordBlobStore(flowID string, blobStore blobstore.BlobStoreClient) {
+	s.blobStores.Store(flowID, blobStore)
+}
+
+func apiError(op string, err error) error {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, engine.ErrFlowNotFound), errors.Is(err, engine.ErrStageNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, engine.ErrFlowTerminated):
+		code = http.StatusConflict
+	case errors.Is(err, engine.ErrInvalidStage):
+		code = http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		code = http.StatusRequestTimeout
+	}
+	return runtime.NewAPIError(op, err.Error(), code)
+}
+
+func (s *service) CreateGraph(params *flowSvc.CreateGraphParams) (*flowSvc.CreateGraphOK, error) {
+	flowID := s.engine.CreateFlow(params.Body.FunctionID)
+	if s.onCreated != nil {
+		s.onCreated(flowID)
+	}
+	return &flowSvc.CreateGraphOK{Payload: &models.ModelCreateGraphResponse{FlowID: flowID}}, nil
+}
+
+func (s *service) AddStage(params *flowSvc.AddStageParams) (*flowSvc.AddStageOK, error) {
+	b := params.Body
+	stageID, err := s.engine.AddStage(params.FlowID, b.Operation, b.Closure, b.Deps, b.CodeLocation, b.Signal)
+	if err != nil {
+		return nil, apiError("addStage", err)
+	}
+	return &flowSvc.AddStageOK{Payload: &models.ModelAddStageResponse{FlowID: params.FlowID, StageID: stageID}}, nil
+}
+
+func (s *service) AddValueStage(params *flowSvc.AddValueStageParams) (*flowSvc.AddValueStageOK, error) {
+	stageID, err := s.engine.AddValue(params.FlowID, params.Body.Value, params.Body.CodeLocation)
+	if err != nil {
+		return nil, apiError("addValueStage", err)
+	}
+	return &flowSvc.AddValueStageOK{Payload: &models.ModelAddStageResponse{FlowID: params.FlowID, StageID: stageID}}, nil
+}
+
+func (s *service) AddDelay(params *flowSvc.AddDelayParams) (*flowSvc.AddDelayOK, error) {
+	delay := time.Duration(params.Body.DelayMs) * time.Millisecond
+	stageID, err := s.engine.AddDelay(params.FlowID, delay, params.Body.CodeLocation)
+	if err != nil {
+		return nil, apiError("addDelay", err)
+	}
+	return &flowSvc.AddDelayOK{Payload: &models.ModelAddStageResponse{FlowID: params.FlowID, StageID: stageID}}, nil
+}
+
+func (s *service) AddInvokeFunction(params *flowSvc.AddInvokeFunctionParams) (*flowSvc.AddInvokeFunctionOK, error) {
+	b := params.Body
+	stageID, err := s.engine.AddInvoke(params.FlowID, b.FunctionID, b.Arg, b.CodeLocation)
+	if err != nil {
+		return nil, apiError("addInvokeFunction", err)
+	}
+	return &flowSvc.AddInvokeFunctionOK{Payload: &models.ModelAddStageResponse{FlowID: params.FlowID, StageID: stageID}}, nil
+}
+
+func (s *service) AwaitStageResult(params *flowSvc.AwaitStageResultParams) (*flowSvc.AwaitStageResultOK, error) {
+	ctx := params.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if params.TimeoutMs != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(*params.TimeoutMs)*time.Millisecond)
+		defer cancel()
+	}
+	result, err := s.engine.Await(ctx, params.FlowID, params.StageID)
+	if err != nil {
+		return nil, apiError("awaitStageResult", err)
+	}
+	return &flowSvc.AwaitStageResultOK{Payload: &models.ModelAwaitStageResultResponse{
+		FlowID:  params.FlowID,
+		StageID: params.StageID,
+		Result:  result,
+	}}, nil
+}
+
+func (s *service) CompleteStageExternally(params *flowSvc.CompleteStageExternallyParams) (*flowSvc.CompleteStageExternallyOK, error) {
+	ok, err := s.engine.Complete(params.FlowID, params.StageID, params.Body.Value)
+	if err != nil {
+		return nil, apiError("completeStageExternally", err)
+	}
+	return &flowSvc.CompleteStageExternallyOK{Payload: &models.ModelCompleteStageExternallyResponse{
+		FlowID:     params.FlowID,
+		StageID:    params.StageID,
+		Successful: ok,
+	}}, nil
+}
+
+func (s *service) Commit(params *flowSvc.CommitParams) (*flowSvc.CommitOK, error) {
+	if err := s.engine.Commit(params.FlowID); err != nil {
+		return nil, apiError("commit", err)
+	}
+	return &flowSvc.CommitOK{Payload: &models.ModelGraphRequestProcessedResponse{FlowID: params.FlowID}}, nil
+}
+
+func (s *service) GetGraphState(params *flowSvc.GetGraphStateParams) (*flowSvc.GetGraphStateOK, error) {
+	// as the HTTP transport would, fail requests whose context is done
+	if params.Context != nil && params.Context.Err() != nil {
+		return nil, params.Context.Err()
+	}
+	state, err := s.engine.State(params.FlowID)
+	if err != nil {
+		return nil, apiError("getGraphState", err)
+	}
+	return &flowSvc.GetGraphStateOK{Payload: state}, nil
+}
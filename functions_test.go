@@ -0,0 +1,177 @@
+package flow_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	fdk "github.com/fnproject/fdk-go"
+	flow "github.com/fnproject/flow-lib-go"
+	"github.com/fnproject/flow-lib-go/flowtest"
+)
+
+// functionFailures receives the failures of function invocations seen by
+// the flows under test
+var functionFailures = make(chan error, 2)
+
+func recordFunctionFailure(err error) *flow.HTTPResponse {
+	functionFailures <- err
+	return nil
+}
+
+func init() {
+	if err := flow.RegisterAction(recordFunctionFailure); err != nil {
+		panic(err)
+	}
+}
+
+// runFunctions runs a flow built by build against a harness with stubs,
+// failing the test if it doesn't terminate
+func runFunctions(t *testing.T, stubs map[string]flowtest.Stub, build func(fl flow.Flow)) {
+	t.Helper()
+	h := flowtest.New(flow.WithFlow(fdk.HandlerFunc(func(ctx context.Context, in io.Reader, out io.Writer) {
+		build(flow.FromContext(ctx))
+	})))
+	for functionID, stub := range stubs {
+		h.Stub(functionID, stub)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := h.Invoke(ctx, strings.NewReader("")).Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func nextFunctionFailure(t *testing.T) *flow.FunctionError {
+	t.Helper()
+	select {
+	case err := <-functionFailures:
+		var fnErr *flow.FunctionError
+		if !errors.As(err, &fnErr) {
+			t.Fatalf("got %T %v, want a FunctionError", err, err)
+		}
+		return fnErr
+	default:
+		t.Fatal("the function invocation didn't fail")
+		return nil
+	}
+}
+
+func TestFunctionError(t *testing.T) {
+	stubs := map[string]flowtest.Stub{
+		"app/teapot": func(req *flow.HTTPRequest) (*flow.HTTPResponse, error) {
+			headers := make(http.Header)
+			headers.Set("X-Reason", "short and stout")
+			return &flow.HTTPResponse{StatusCode: http.StatusTeapot, Headers: headers, Body: []byte("no coffee")}, nil
+		},
+	}
+	var awaited error
+	runFunctions(t, stubs, func(fl flow.Flow) {
+		f := fl.InvokeFunction("app/teapot", &flow.HTTPRequest{Method: "POST"})
+		f.Exceptionally(recordFunctionFailure)
+		_, awaited = f.GetWithTimeout(5 * time.Second)
+	})
+
+	// awaiting the invocation's own future knows the function, while
+	// dependent stages don't
+	functionFailures <- awaited
+	for _, want := range []string{"", "app/teapot"} {
+		fnErr := nextFunctionFailure(t)
+		if fnErr.FunctionID != want {
+			t.Errorf("got function ID %q, want %q", fnErr.FunctionID, want)
+		}
+		if fnErr.StatusCode != http.StatusTeapot {
+			t.Errorf("got status %d, want %d", fnErr.StatusCode, http.StatusTeapot)
+		}
+		if got := fnErr.Headers.Get("X-Reason"); got != "short and stout" {
+			t.Errorf("got X-Reason %q, want %q", got, "short and stout")
+		}
+		if !reflect.DeepEqual(fnErr.Body, []byte("no coffee")) {
+			t.Errorf("got body %q, want %q", fnErr.Body, "no coffee")
+		}
+	}
+}
+
+type greeting struct {
+	Name  string `json:"name"`
+	Greet string `json:"greet,omitempty"`
+}
+
+func TestInvokeFunctionJSON(t *testing.T) {
+	requests := make(chan *flow.HTTPRequest, 1)
+	stubs := map[string]flowtest.Stub{
+		"app/greeter": func(req *flow.HTTPRequest) (*flow.HTTPResponse, error) {
+			requests <- req
+			headers := make(http.Header)
+			headers.Set(flow.ContentTypeHeader, flow.JSONMediaHeader)
+			return &flow.HTTPResponse{StatusCode: http.StatusOK, Headers: headers, Body: []byte(`{"name":"world","greet":"hello"}`)}, nil
+		},
+	}
+	var got greeting
+	var err error
+	runFunctions(t, stubs, func(fl flow.Flow) {
+		f := flow.InvokeFunctionJSON[greeting](fl, "app/greeter", greeting{Name: "world"},
+			flow.WithMethod("PUT"), flow.WithHeader("X-Trace", "abc"))
+		got, err = f.GetWithTimeout(5 * time.Second)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (greeting{Name: "world", Greet: "hello"}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	req := <-requests
+	if req.Method != "PUT" {
+		t.Errorf("got method %s, want PUT", req.Method)
+	}
+	if string(req.Body) != `{"name":"world"}` {
+		t.Errorf("got body %s, want the JSON encoding of the input", req.Body)
+	}
+	for key, want := range map[string]string{flow.ContentTypeHeader: flow.JSONMediaHeader, "Accept": flow.JSONMediaHeader, "X-Trace": "abc"} {
+		if got := req.Headers.Get(key); got != want {
+			t.Errorf("got header %s %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestInvokeFunctionJSONErrorStatus(t *testing.T) {
+	stubs := map[string]flowtest.Stub{
+		"app/greeter": func(req *flow.HTTPRequest) (*flow.HTTPResponse, error) {
+			return &flow.HTTPResponse{StatusCode: http.StatusBadRequest, Body: []byte("who?")}, nil
+		},
+	}
+	var err error
+	runFunctions(t, stubs, func(fl flow.Flow) {
+		_, err = flow.InvokeFunctionJSON[greeting](fl, "app/greeter", greeting{}).GetWithTimeout(5 * time.Second)
+	})
+
+	var fnErr *flow.FunctionError
+	if !errors.As(err, &fnErr) {
+		t.Fatalf("got %T %v, want a FunctionError", err, err)
+	}
+	if fnErr.FunctionID != "app/greeter" || fnErr.StatusCode != http.StatusBadRequest || string(fnErr.Body) != "who?" {
+		t.Errorf("got %+v, want a 400 from app/greeter", fnErr)
+	}
+}
+
+func TestInvokeFunctionJSONEncodeError(t *testing.T) {
+	stubs := map[string]flowtest.Stub{
+		"app/greeter": func(req *flow.HTTPRequest) (*flow.HTTPResponse, error) {
+			t.Error("invoked the function with an unencodable request")
+			return nil, errors.New("unexpected")
+		},
+	}
+	var err error
+	runFunctions(t, stubs, func(fl flow.Flow) {
+		err = flow.InvokeFunctionJSON[greeting](fl, "app/greeter", make(chan int)).Err()
+	})
+	if err == nil || !strings.Contains(err.Error(), "app/greeter") {
+		t.Errorf("got %v, want an error encoding the request to app/greeter", err)
+	}
+}
@@ -1,16 +1,30 @@
 module github.com/fnproject/flow-lib-go
 
+go 1.18
+
 require (
 	github.com/fnproject/fdk-go v0.0.0-20190102214815-bd24a5aa63cf
-	github.com/go-openapi/analysis v0.18.0 // indirect
 	github.com/go-openapi/errors v0.18.0
-	github.com/go-openapi/jsonreference v0.18.0 // indirect
-	github.com/go-openapi/loads v0.18.0 // indirect
 	github.com/go-openapi/runtime v0.18.0
-	github.com/go-openapi/spec v0.18.0 // indirect
 	github.com/go-openapi/strfmt v0.17.2
 	github.com/go-openapi/swag v0.18.0
 	github.com/go-openapi/validate v0.18.0
-	github.com/kr/pretty v0.1.0 // indirect
 	golang.org/x/net v0.0.0-20181220203305-927f97764cc3
 )
+
+require (
+	github.com/PuerkitoBio/purell v1.1.0 // indirect
+	github.com/PuerkitoBio/urlesc v0.0.0-20170810143723-de5bf2ad4578 // indirect
+	github.com/asaskevich/govalidator v0.0.0-20180720115003-f9ffefc3facf // indirect
+	github.com/globalsign/mgo v0.0.0-20181015135952-eeefdecb41b8 // indirect
+	github.com/go-openapi/analysis v0.18.0 // indirect
+	github.com/go-openapi/jsonpointer v0.17.2 // indirect
+	github.com/go-openapi/jsonreference v0.18.0 // indirect
+	github.com/go-openapi/loads v0.18.0 // indirect
+	github.com/go-openapi/spec v0.18.0 // indirect
+	github.com/kr/pretty v0.1.0 // indirect
+	github.com/mailru/easyjson v0.0.0-20180823135443-60711f1a8329 // indirect
+	github.com/mitchellh/mapstructure v1.1.2 // indirect
+	golang.org/x/text v0.3.0 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
+)
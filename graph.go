@@ -0,0 +1,135 @@
+package flow
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fnproject/flow-lib-go/events"
+)
+
+// ReplayState rebuilds the state of a flow from its events, such as those
+// read from a saved event stream with events.ReadAll. Unlike states from
+// the flow service, stages have their code locations.
+func ReplayState(evs []events.Event) *GraphState {
+	g := &GraphState{}
+	stages := make(map[string]*StageState)
+	for _, ev := range evs {
+		if g.FlowID == "" {
+			g.FlowID = ev.EventHeader().FlowID
+		}
+		switch ev := ev.(type) {
+		case *events.GraphCreated:
+			g.FunctionID = ev.FunctionID
+		case *events.StageAdded:
+			s := &StageState{
+				ID:           ev.StageID,
+				Operation:    ev.Op,
+				Status:       StagePending,
+				Dependencies: ev.Dependencies,
+				CodeLocation: ev.CodeLocation,
+			}
+			stages[s.ID] = s
+			g.Stages = append(g.Stages, s)
+		case *events.FunctionStarted:
+			if s, ok := stages[ev.StageID]; ok && !s.Status.Complete() {
+				s.Status = StageRunning
+			}
+		case *events.StageCompleted:
+			if s, ok := stages[ev.StageID]; ok {
+				s.Status = StageFailed
+				if ev.Result.Successful {
+					s.Status = StageSuccessful
+				}
+			}
+		}
+	}
+	return g
+}
+
+// colours of stages by status, as DOT colour names and mermaid fills
+var statusColours = map[StageStatus][2]string{
+	StagePending:    {"lightgrey", "#d3d3d3"},
+	StageRunning:    {"lightgoldenrod", "#eedd82"},
+	StageSuccessful: {"palegreen", "#98fb98"},
+	StageFailed:     {"salmon", "#fa8072"},
+}
+
+func statusColour(status StageStatus, i int) string {
+	if c, ok := statusColours[status]; ok {
+		return c[i]
+	}
+	return statusColours[StagePending][i]
+}
+
+func stageLabel(s *StageState) []string {
+	label := []string{fmt.Sprintf("%s: %s", s.ID, s.Operation)}
+	if s.CodeLocation != "" {
+		label = append(label, s.CodeLocation)
+	}
+	return label
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language, with a node for
+// each stage coloured by its status and edges from each stage to the
+// stages depending on it:
+//
+//	state.WriteDOT(os.Stdout) // | dot -Tsvg > flow.svg
+func (g *GraphState) WriteDOT(w io.Writer) error {
+	quote := func(s string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+	}
+
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "digraph %s {\n", quote(g.FlowID))
+	fmt.Fprintf(b, "  node [shape=box, style=\"rounded,filled\"];\n")
+	for _, s := range g.Stages {
+		fmt.Fprintf(b, "  %s [label=%s, fillcolor=%s];\n",
+			quote(s.ID), quote(strings.Join(stageLabel(s), "\n")), quote(statusColour(s.Status, 0)))
+	}
+	for _, s := range g.Stages {
+		for _, dep := range s.Dependencies {
+			fmt.Fprintf(b, "  %s -> %s;\n", quote(dep), quote(s.ID))
+		}
+	}
+	fmt.Fprintf(b, "}\n")
+	return b.Flush()
+}
+
+// WriteMermaid writes the graph as a mermaid flowchart, with the same nodes
+// and edges as WriteDOT
+func (g *GraphState) WriteMermaid(w io.Writer) error {
+	node := func(id string) string {
+		return "s" + strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+				return r
+			}
+			return '_'
+		}, id)
+	}
+	escape := strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;", "\n", "<br/>")
+
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "flowchart TD\n")
+	for _, status := range []StageStatus{StagePending, StageRunning, StageSuccessful, StageFailed} {
+		fmt.Fprintf(b, "  classDef %s fill:%s\n", status, statusColour(status, 1))
+	}
+	for _, s := range g.Stages {
+		label := stageLabel(s)
+		for i := range label {
+			label[i] = escape.Replace(label[i])
+		}
+		class := s.Status
+		if _, ok := statusColours[class]; !ok {
+			class = StagePending
+		}
+		fmt.Fprintf(b, "  %s[\"%s\"]:::%s\n", node(s.ID), strings.Join(label, "<br/>"), class)
+	}
+	for _, s := range g.Stages {
+		for _, dep := range s.Dependencies {
+			fmt.Fprintf(b, "  %s --> %s\n", node(dep), node(s.ID))
+		}
+	}
+	return b.Flush()
+}
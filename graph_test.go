@@ -0,0 +1,90 @@
+package flow
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fnproject/flow-lib-go/events"
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// replayedEvents are the events of a flow with a stage in each status,
+// whose code locations need quoting
+func replayedEvents() []events.Event {
+	at := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	header := func(seq uint64) events.Header {
+		return events.Header{FlowID: "flow-1", Seq: seq, Time: at}
+	}
+	return []events.Event{
+		&events.GraphCreated{Header: header(1), FunctionID: "app/fn"},
+		&events.StageAdded{Header: header(2), StageID: "0", Op: models.ModelCompletionOperationSupply, CodeLocation: `main.go:10 "quoted"`},
+		&events.StageAdded{Header: header(3), StageID: "1", Op: models.ModelCompletionOperationThenApply, Dependencies: []string{"0"}, CodeLocation: "two\nlines <b>"},
+		&events.StageAdded{Header: header(4), StageID: "2", Op: models.ModelCompletionOperationCompletedValue},
+		&events.StageAdded{Header: header(5), StageID: "3", Op: models.ModelCompletionOperationAllOf, Dependencies: []string{"1", "2"}, CodeLocation: `C:\flows\main.go:20`},
+		&events.StageCompleted{Header: header(6), StageID: "0", Result: events.Result{Successful: true}},
+		&events.FunctionStarted{Header: header(7), StageID: "1", FunctionID: "app/fn"},
+		&events.StageCompleted{Header: header(8), StageID: "2", Result: events.Result{Successful: false}},
+		// events of unknown stages are ignored
+		&events.StageCompleted{Header: header(9), StageID: "9", Result: events.Result{Successful: true}},
+	}
+}
+
+func TestReplayState(t *testing.T) {
+	g := ReplayState(replayedEvents())
+	if g.FlowID != "flow-1" || g.FunctionID != "app/fn" {
+		t.Errorf("got flow %s of %s, want flow-1 of app/fn", g.FlowID, g.FunctionID)
+	}
+	var statuses []StageStatus
+	for _, s := range g.Stages {
+		statuses = append(statuses, s.Status)
+	}
+	want := []StageStatus{StageSuccessful, StageRunning, StageFailed, StagePending}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("got statuses %v, want %v", statuses, want)
+	}
+	if s := g.Stage("3"); !reflect.DeepEqual(s.Dependencies, []string{"1", "2"}) || s.CodeLocation != `C:\flows\main.go:20` {
+		t.Errorf("got stage 3 %+v, want its dependencies and code location", s)
+	}
+}
+
+// checkGolden compares got with the golden file testdata/name, or updates
+// the file when testing with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got:\n%s\nwant %s:\n%s", got, path, want)
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	var buf bytes.Buffer
+	if err := ReplayState(replayedEvents()).WriteDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "graph.dot", buf.Bytes())
+}
+
+func TestWriteMermaid(t *testing.T) {
+	var buf bytes.Buffer
+	if err := ReplayState(replayedEvents()).WriteMermaid(&buf); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "graph.mmd", buf.Bytes())
+}
@@ -0,0 +1,697 @@
+// Package engine executes flow graphs in memory, following the semantics
+// of the flow service closely enough to run flows without one. It backs
+// the flowtest package and the local completer.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+var (
+	ErrFlowNotFound   = errors.New("Flow not found")
+	ErrStageNotFound  = errors.New("Stage not found")
+	ErrFlowTerminated = errors.New("Flow has terminated")
+	ErrInvalidStage   = errors.New("Invalid stage")
+)
+
+// Invoker runs the work of stages on behalf of the engine
+type Invoker interface {
+	// InvokeStage invokes the continuation of a stage with the given args
+	InvokeStage(flowID string, functionID string, stageID string, closure *models.ModelBlobDatum, args []*models.ModelCompletionResult) (*models.ModelCompletionResult, error)
+	// InvokeFunction invokes a function with the request of an invokeFunction stage
+	InvokeFunction(flowID string, functionID string, arg *models.ModelHTTPReqDatum) (*models.ModelHTTPRespDatum, error)
+}
+
+// Clock schedules delay stages and timestamps events
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func())
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) {
+	time.AfterFunc(d, f)
+}
+
+// RealClock schedules delays using wall clock time
+var RealClock Clock = realClock{}
+
+type stage struct {
+	id         string
+	op         models.ModelCompletionOperation
+	closure    *models.ModelBlobDatum
+	deps       []*stage
+	loc        string
+	signal     string
+	started    bool
+	composedTo *stage
+	result     *models.ModelCompletionResult
+	done       chan struct{}
+}
+
+func (s *stage) complete() bool {
+	return s.result != nil
+}
+
+type graph struct {
+	flowID      string
+	functionID  string
+	stages      []*stage
+	committed   bool
+	terminating bool
+	terminated  chan struct{}
+	events      *eventLog[*models.ModelGraphEvent]
+}
+
+// Engine holds the flows created with it and runs their stages as they
+// become ready
+type Engine struct {
+	mu        sync.Mutex
+	invoker   Invoker
+	clock     Clock
+	nextID    int
+	flows     map[string]*graph
+	lifecycle *eventLog[*models.ModelGraphLifecycleEvent]
+}
+
+func New(invoker Invoker, clock Clock) *Engine {
+	return &Engine{
+		invoker:   invoker,
+		clock:     clock,
+		flows:     make(map[string]*graph),
+		lifecycle: newEventLog[*models.ModelGraphLifecycleEvent](),
+	}
+}
+
+// CreateFlow creates an empty flow for the given function
+func (e *Engine) CreateFlow(functionID string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.nextID++
+	flowID := fmt.Sprintf("flow-%d", e.nextID)
+	g := &graph{
+		flowID:     flowID,
+		functionID: functionID,
+		terminated: make(chan struct{}),
+		events:     newEventLog[*models.ModelGraphEvent](),
+	}
+	e.flows[flowID] = g
+
+	created := &models.ModelGraphCreatedEvent{FlowID: flowID, FunctionID: functionID, Ts: e.now()}
+	e.emit(g, &models.ModelGraphEvent{GraphCreated: created})
+	e.emitLifecycle(&models.ModelGraphLifecycleEvent{FlowID: flowID, GraphCreated: created})
+	return flowID
+}
+
+// AddStage adds a stage running a continuation, or combining the results
+// of its dependencies for allOf and anyOf. An externalCompletion stage may
+// name the signal it waits for, which State reports.
+func (e *Engine) AddStage(flowID string, op models.ModelCompletionOperation, closure *models.ModelBlobDatum, deps []string, loc string, signal string) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	g, err := e.openGraph(flowID)
+	if err != nil {
+		return "", err
+	}
+	s := &stage{op: op, closure: closure, loc: loc, signal: signal}
+	for _, dep := range deps {
+		d, err := g.stage(dep)
+		if err != nil {
+			return "", err
+		}
+		s.deps = append(s.deps, d)
+	}
+	if err := validate(s); err != nil {
+		return "", err
+	}
+	e.add(g, s)
+	return s.id, nil
+}
+
+// AddValue adds a stage completed with the given result
+func (e *Engine) AddValue(flowID string, result *models.ModelCompletionResult, loc string) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	g, err := e.openGraph(flowID)
+	if err != nil {
+		return "", err
+	}
+	s := &stage{op: models.ModelCompletionOperationCompletedValue, loc: loc, started: true}
+	e.add(g, s)
+	e.complete(g, s, result)
+	return s.id, nil
+}
+
+// AddDelay adds a stage that completes with an empty value once the delay
+// has passed on the engine's clock
+func (e *Engine) AddDelay(flowID string, delay time.Duration, loc string) (string, error) {
+	e.mu.Lock()
+	g, err := e.openGraph(flowID)
+	if err != nil {
+		e.mu.Unlock()
+		return "", err
+	}
+	s := &stage{op: models.ModelCompletionOperationDelay, loc: loc, started: true}
+	e.add(g, s)
+	e.emit(g, &models.ModelGraphEvent{DelayScheduled: &models.ModelDelayScheduledEvent{
+		FlowID:  flowID,
+		StageID: s.id,
+		TimeMs:  unixMs(e.clock.Now().Add(delay)),
+		Ts:      e.now(),
+	}})
+	e.mu.Unlock()
+
+	e.clock.AfterFunc(delay, func() {
+		e.completeStage(g, s, EmptyResult())
+	})
+	return s.id, nil
+}
+
+// AddInvoke adds a stage that invokes a function
+func (e *Engine) AddInvoke(flowID string, functionID string, arg *models.ModelHTTPReqDatum, loc string) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	g, err := e.openGraph(flowID)
+	if err != nil {
+		return "", err
+	}
+	s := &stage{op: models.ModelCompletionOperationInvokeFunction, loc: loc}
+	e.add(g, s)
+	s.started = true
+	go func() {
+		e.completeStage(g, s, e.invokeFunction(g, s, functionID, arg))
+	}()
+	return s.id, nil
+}
+
+// Await blocks until the stage is complete or ctx is done
+func (e *Engine) Await(ctx context.Context, flowID string, stageID string) (*models.ModelCompletionResult, error) {
+	e.mu.Lock()
+	g, err := e.graph(flowID)
+	if err != nil {
+		e.mu.Unlock()
+		return nil, err
+	}
+	s, err := g.stage(stageID)
+	e.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-s.done:
+		return s.result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Complete completes an externally completable stage, returning false if
+// it was already complete
+func (e *Engine) Complete(flowID string, stageID string, result *models.ModelCompletionResult) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	g, err := e.graph(flowID)
+	if err != nil {
+		return false, err
+	}
+	s, err := g.stage(stageID)
+	if err != nil {
+		return false, err
+	}
+	if s.op != models.ModelCompletionOperationExternalCompletion {
+		return false, fmt.Errorf("%w: stage %s can't be completed externally", ErrInvalidStage, stageID)
+	}
+	if s.complete() {
+		return false, nil
+	}
+	e.complete(g, s, result)
+	return true, nil
+}
+
+// Commit marks the flow as committed, after which it terminates once all
+// its stages are complete
+func (e *Engine) Commit(flowID string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	g, ok := e.flows[flowID]
+	if !ok {
+		return ErrFlowNotFound
+	}
+	if !g.committed {
+		g.committed = true
+		e.emit(g, &models.ModelGraphEvent{GraphCommitted: &models.ModelGraphCommittedEvent{FlowID: flowID, Ts: e.now()}})
+	}
+	e.checkTermination(g)
+	return nil
+}
+
+// Terminated returns a channel that is closed once the flow has terminated
+// and its termination hooks have run
+func (e *Engine) Terminated(flowID string) (<-chan struct{}, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	g, ok := e.flows[flowID]
+	if !ok {
+		return nil, ErrFlowNotFound
+	}
+	return g.terminated, nil
+}
+
+func (e *Engine) graph(flowID string) (*graph, error) {
+	g, ok := e.flows[flowID]
+	if !ok {
+		return nil, ErrFlowNotFound
+	}
+	return g, nil
+}
+
+// openGraph returns the flow if stages can still be added to it
+func (e *Engine) openGraph(flowID string) (*graph, error) {
+	g, err := e.graph(flowID)
+	if err != nil {
+		return nil, err
+	}
+	if g.terminating {
+		return nil, ErrFlowTerminated
+	}
+	return g, nil
+}
+
+func (g *graph) stage(stageID string) (*stage, error) {
+	i, err := strconv.Atoi(stageID)
+	if err != nil || i < 0 || i >= len(g.stages) {
+		return nil, ErrStageNotFound
+	}
+	return g.stages[i], nil
+}
+
+func validate(s *stage) error {
+	var minDeps, maxDeps int
+	needsClosure := true
+	switch s.op {
+	case models.ModelCompletionOperationSupply, models.ModelCompletionOperationTerminationHook:
+	case models.ModelCompletionOperationExternalCompletion:
+		needsClosure = false
+	case models.ModelCompletionOperationThenApply, models.ModelCompletionOperationThenAccept,
+		models.ModelCompletionOperationThenRun, models.ModelCompletionOperationThenCompose,
+		models.ModelCompletionOperationWhenComplete, models.ModelCompletionOperationHandle,
+		models.ModelCompletionOperationExceptionally, models.ModelCompletionOperationExceptionallyCompose:
+		minDeps, maxDeps = 1, 1
+	case models.ModelCompletionOperationThenCombine, models.ModelCompletionOperationThenAcceptBoth,
+		models.ModelCompletionOperationAcceptEither, models.ModelCompletionOperationApplyToEither:
+		minDeps, maxDeps = 2, 2
+	case models.ModelCompletionOperationAllOf, models.ModelCompletionOperationAnyOf:
+		minDeps, maxDeps = 0, -1
+		needsClosure = false
+	default:
+		return fmt.Errorf("%w: unsupported operation %v", ErrInvalidStage, s.op)
+	}
+	if s.op == models.ModelCompletionOperationAnyOf {
+		minDeps = 1
+	}
+	if len(s.deps) < minDeps || (maxDeps >= 0 && len(s.deps) > maxDeps) {
+		return fmt.Errorf("%w: %v stage can't have %d dependencies", ErrInvalidStage, s.op, len(s.deps))
+	}
+	if needsClosure && s.closure == nil {
+		return fmt.Errorf("%w: %v stage requires a closure", ErrInvalidStage, s.op)
+	}
+	if s.signal != "" && s.op != models.ModelCompletionOperationExternalCompletion {
+		return fmt.Errorf("%w: %v stage can't wait for a signal", ErrInvalidStage, s.op)
+	}
+	return nil
+}
+
+// add adds the stage to the graph and starts it if possible
+func (e *Engine) add(g *graph, s *stage) {
+	s.id = strconv.Itoa(len(g.stages))
+	s.done = make(chan struct{})
+	g.stages = append(g.stages, s)
+
+	deps := make([]string, 0, len(s.deps))
+	for _, d := range s.deps {
+		deps = append(deps, d.id)
+	}
+	e.emit(g, &models.ModelGraphEvent{StageAdded: &models.ModelStageAddedEvent{
+		FlowID:       g.flowID,
+		StageID:      s.id,
+		Op:           s.op,
+		Closure:      s.closure,
+		CodeLocation: s.loc,
+		Dependencies: deps,
+		Ts:           e.now(),
+	}})
+	e.start(g, s)
+}
+
+// schedule starts all stages that have become ready to run
+func (e *Engine) schedule(g *graph) {
+	for _, s := range g.stages {
+		if s.complete() {
+			continue
+		}
+		if s.composedTo != nil {
+			if s.composedTo.complete() {
+				e.complete(g, s, s.composedTo.result)
+			}
+			continue
+		}
+		if !s.started {
+			e.start(g, s)
+		}
+	}
+}
+
+// start runs the stage if its dependencies allow, and must be called with
+// the engine's lock held
+func (e *Engine) start(g *graph, s *stage) {
+	var run func() *models.ModelCompletionResult
+	switch s.op {
+	case models.ModelCompletionOperationExternalCompletion, models.ModelCompletionOperationTerminationHook,
+		models.ModelCompletionOperationDelay, models.ModelCompletionOperationInvokeFunction:
+		return
+
+	case models.ModelCompletionOperationSupply:
+		run = e.invoke(g, s)
+
+	case models.ModelCompletionOperationThenApply, models.ModelCompletionOperationThenAccept,
+		models.ModelCompletionOperationThenCompose:
+		if !allComplete(s.deps) {
+			return
+		}
+		if dep := s.deps[0].result; !dep.Successful {
+			run = result(dep)
+		} else {
+			run = e.invoke(g, s, dep)
+		}
+
+	case models.ModelCompletionOperationThenRun:
+		if !allComplete(s.deps) {
+			return
+		}
+		if dep := s.deps[0].result; !dep.Successful {
+			run = result(dep)
+		} else {
+			run = e.invoke(g, s)
+		}
+
+	case models.ModelCompletionOperationWhenComplete:
+		if !allComplete(s.deps) {
+			return
+		}
+		dep := s.deps[0].result
+		invoke := e.invoke(g, s, valueArgs(dep)...)
+		run = func() *models.ModelCompletionResult {
+			if r := invoke(); !r.Successful && dep.Successful {
+				return r
+			}
+			return dep
+		}
+
+	case models.ModelCompletionOperationHandle:
+		if !allComplete(s.deps) {
+			return
+		}
+		run = e.invoke(g, s, valueArgs(s.deps[0].result)...)
+
+	case models.ModelCompletionOperationExceptionally, models.ModelCompletionOperationExceptionallyCompose:
+		if !allComplete(s.deps) {
+			return
+		}
+		if dep := s.deps[0].result; dep.Successful {
+			run = result(dep)
+		} else {
+			run = e.invoke(g, s, dep)
+		}
+
+	case models.ModelCompletionOperationThenCombine, models.ModelCompletionOperationThenAcceptBoth:
+		if !allComplete(s.deps) {
+			return
+		}
+		if failed := firstFailure(s.deps); failed != nil {
+			run = result(failed)
+		} else {
+			run = e.invoke(g, s, s.deps[0].result, s.deps[1].result)
+		}
+
+	case models.ModelCompletionOperationAcceptEither, models.ModelCompletionOperationApplyToEither:
+		first := firstComplete(s.deps)
+		if first == nil {
+			return
+		}
+		if !first.Successful {
+			run = result(first)
+		} else {
+			run = e.invoke(g, s, first)
+		}
+
+	case models.ModelCompletionOperationAnyOf:
+		first := firstComplete(s.deps)
+		if first == nil {
+			return
+		}
+		run = result(first)
+
+	case models.ModelCompletionOperationAllOf:
+		if !allComplete(s.deps) {
+			return
+		}
+		if failed := firstFailure(s.deps); failed != nil {
+			run = result(failed)
+		} else {
+			run = result(EmptyResult())
+		}
+
+	default:
+		return
+	}
+
+	s.started = true
+	go func() {
+		r := run()
+		if s.op == models.ModelCompletionOperationThenCompose || s.op == models.ModelCompletionOperationExceptionallyCompose {
+			if ref := r.Datum.StageRef; r.Successful && ref != nil && r != s.deps[0].result {
+				e.compose(g, s, ref.StageID)
+				return
+			}
+		}
+		e.completeStage(g, s, r)
+	}()
+}
+
+// invoke returns a function that invokes the stage's continuation
+func (e *Engine) invoke(g *graph, s *stage, args ...*models.ModelCompletionResult) func() *models.ModelCompletionResult {
+	return func() *models.ModelCompletionResult {
+		e.emitLocked(g, &models.ModelGraphEvent{FaasInvocationStarted: &models.ModelFaasInvocationStartedEvent{
+			FlowID:     g.flowID,
+			StageID:    s.id,
+			FunctionID: g.functionID,
+			Ts:         e.now(),
+		}})
+		r, err := e.invoker.InvokeStage(g.flowID, g.functionID, s.id, s.closure, args)
+		switch {
+		case err != nil:
+			r = ErrorResult(models.ModelErrorDatumTypeStageFailed, err.Error())
+		case r == nil || r.Datum == nil:
+			r = ErrorResult(models.ModelErrorDatumTypeInvalidStageResponse, "Stage returned no result")
+		}
+		e.emitLocked(g, &models.ModelGraphEvent{FaasInvocationCompleted: &models.ModelFaasInvocationCompletedEvent{
+			FlowID:  g.flowID,
+			StageID: s.id,
+			Result:  r,
+			Ts:      e.now(),
+		}})
+		return r
+	}
+}
+
+func (e *Engine) invokeFunction(g *graph, s *stage, functionID string, arg *models.ModelHTTPReqDatum) *models.ModelCompletionResult {
+	e.emitLocked(g, &models.ModelGraphEvent{FaasInvocationStarted: &models.ModelFaasInvocationStartedEvent{
+		FlowID:     g.flowID,
+		StageID:    s.id,
+		FunctionID: functionID,
+		Ts:         e.now(),
+	}})
+	var r *models.ModelCompletionResult
+	if resp, err := e.invoker.InvokeFunction(g.flowID, functionID, arg); err != nil {
+		r = ErrorResult(models.ModelErrorDatumTypeFunctionInvokeFailed, err.Error())
+	} else {
+		r = &models.ModelCompletionResult{
+			Successful: resp.StatusCode >= 200 && resp.StatusCode < 300,
+			Datum:      &models.ModelDatum{HTTPResp: resp},
+		}
+	}
+	e.emitLocked(g, &models.ModelGraphEvent{FaasInvocationCompleted: &models.ModelFaasInvocationCompletedEvent{
+		FlowID:  g.flowID,
+		StageID: s.id,
+		Result:  r,
+		Ts:      e.now(),
+	}})
+	return r
+}
+
+// compose completes the stage with the result of the referenced stage
+func (e *Engine) compose(g *graph, s *stage, stageID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ref, err := g.stage(stageID)
+	if err != nil {
+		e.complete(g, s, ErrorResult(models.ModelErrorDatumTypeInvalidStageResponse, fmt.Sprintf("Composed stage %s not found", stageID)))
+		return
+	}
+	s.composedTo = ref
+	e.emit(g, &models.ModelGraphEvent{StageComposed: &models.ModelStageComposedEvent{
+		FlowID:          g.flowID,
+		StageID:         s.id,
+		ComposedStageID: ref.id,
+		Ts:              e.now(),
+	}})
+	e.schedule(g)
+}
+
+func (e *Engine) completeStage(g *graph, s *stage, r *models.ModelCompletionResult) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.complete(g, s, r)
+}
+
+// complete records the result of a stage, must be called with the lock held
+func (e *Engine) complete(g *graph, s *stage, r *models.ModelCompletionResult) {
+	if s.complete() {
+		return
+	}
+	s.result = r
+	close(s.done)
+	e.emitCompleted(g, s)
+	e.schedule(g)
+	e.checkTermination(g)
+}
+
+func (e *Engine) emitCompleted(g *graph, s *stage) {
+	e.emit(g, &models.ModelGraphEvent{StageCompleted: &models.ModelStageCompletedEvent{
+		FlowID:  g.flowID,
+		StageID: s.id,
+		Result:  s.result,
+		Ts:      e.now(),
+	}})
+}
+
+// checkTermination runs the flow's termination hooks once it is committed
+// and all other stages are complete
+func (e *Engine) checkTermination(g *graph) {
+	if !g.committed || g.terminating {
+		return
+	}
+	var hooks []*stage
+	for _, s := range g.stages {
+		if s.op == models.ModelCompletionOperationTerminationHook {
+			hooks = append(hooks, s)
+		} else if !s.complete() {
+			return
+		}
+	}
+	g.terminating = true
+	e.emit(g, &models.ModelGraphEvent{GraphTerminating: &models.ModelGraphTerminatingEvent{
+		FlowID:     g.flowID,
+		FunctionID: g.functionID,
+		Status:     models.ModelStatusDatumTypeSucceeded,
+		Ts:         e.now(),
+	}})
+	status := &models.ModelCompletionResult{
+		Successful: true,
+		Datum:      &models.ModelDatum{Status: &models.ModelStatusDatum{Type: models.ModelStatusDatumTypeSucceeded}},
+	}
+	go func() {
+		// hooks run in the reverse order to which they were added
+		for i := len(hooks) - 1; i >= 0; i-- {
+			h := hooks[i]
+			e.completeHook(g, h, e.invoke(g, h, status)())
+		}
+		e.completeGraph(g)
+	}()
+}
+
+func (e *Engine) completeHook(g *graph, s *stage, r *models.ModelCompletionResult) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s.result = r
+	close(s.done)
+	e.emitCompleted(g, s)
+}
+
+func (e *Engine) completeGraph(g *graph) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	completed := &models.ModelGraphCompletedEvent{FlowID: g.flowID, FunctionID: g.functionID, Ts: e.now()}
+	e.emit(g, &models.ModelGraphEvent{GraphCompleted: completed})
+	e.emitLifecycle(&models.ModelGraphLifecycleEvent{FlowID: g.flowID, GraphCompleted: completed})
+	g.events.close()
+	close(g.terminated)
+}
+
+func allComplete(stages []*stage) bool {
+	for _, s := range stages {
+		if !s.complete() {
+			return false
+		}
+	}
+	return true
+}
+
+func firstComplete(stages []*stage) *models.ModelCompletionResult {
+	for _, s := range stages {
+		if s.complete() {
+			return s.result
+		}
+	}
+	return nil
+}
+
+func firstFailure(stages []*stage) *models.ModelCompletionResult {
+	for _, s := range stages {
+		if !s.result.Successful {
+			return s.result
+		}
+	}
+	return nil
+}
+
+// valueArgs returns the value and error arguments of whenComplete and
+// handle continuations, one of which is empty
+func valueArgs(r *models.ModelCompletionResult) []*models.ModelCompletionResult {
+	if r.Successful {
+		return []*models.ModelCompletionResult{r, EmptyResult()}
+	}
+	return []*models.ModelCompletionResult{EmptyResult(), r}
+}
+
+func result(r *models.ModelCompletionResult) func() *models.ModelCompletionResult {
+	return func() *models.ModelCompletionResult { return r }
+}
+
+// EmptyResult returns a successful result with an empty datum
+func EmptyResult() *models.ModelCompletionResult {
+	return &models.ModelCompletionResult{
+		Successful: true,
+		Datum:      &models.ModelDatum{Empty: map[string]interface{}{}},
+	}
+}
+
+// ErrorResult returns a failed result raised by the platform
+func ErrorResult(kind models.ModelErrorDatumType, message string) *models.ModelCompletionResult {
+	return &models.ModelCompletionResult{
+		Datum: &models.ModelDatum{Error: &models.ModelErrorDatum{Type: kind, Message: message}},
+	}
+}
@@ -0,0 +1,334 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+// continuation runs a stage's closure in place of a function
+type continuation func(e *Engine, flowID string, args []*models.ModelCompletionResult) *models.ModelCompletionResult
+
+// testInvoker runs closures by looking up their blob IDs
+type testInvoker struct {
+	e             *Engine
+	continuations map[string]continuation
+
+	mu    sync.Mutex
+	calls map[string][]*models.ModelCompletionResult // args by stage ID
+}
+
+func (inv *testInvoker) InvokeStage(flowID string, functionID string, stageID string, closure *models.ModelBlobDatum, args []*models.ModelCompletionResult) (*models.ModelCompletionResult, error) {
+	inv.mu.Lock()
+	inv.calls[stageID] = args
+	inv.mu.Unlock()
+	c, ok := inv.continuations[closure.BlobID]
+	if !ok {
+		return nil, fmt.Errorf("No continuation %s", closure.BlobID)
+	}
+	return c(inv.e, flowID, args), nil
+}
+
+func (inv *testInvoker) InvokeFunction(flowID string, functionID string, arg *models.ModelHTTPReqDatum) (*models.ModelHTTPRespDatum, error) {
+	return nil, errors.New("Functions can't be invoked")
+}
+
+func (inv *testInvoker) args(stageID string) ([]*models.ModelCompletionResult, bool) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	args, ok := inv.calls[stageID]
+	return args, ok
+}
+
+func newTestEngine(continuations map[string]continuation) (*Engine, *testInvoker) {
+	inv := &testInvoker{continuations: continuations, calls: make(map[string][]*models.ModelCompletionResult)}
+	inv.e = New(inv, RealClock)
+	return inv.e, inv
+}
+
+// value returns a successful result identified by its blob ID
+func value(id string) *models.ModelCompletionResult {
+	return &models.ModelCompletionResult{Successful: true, Datum: &models.ModelDatum{Blob: &models.ModelBlobDatum{BlobID: id}}}
+}
+
+// failure returns a failed result identified by its blob ID
+func failure(id string) *models.ModelCompletionResult {
+	return &models.ModelCompletionResult{Datum: &models.ModelDatum{Blob: &models.ModelBlobDatum{BlobID: id}}}
+}
+
+func stageRef(stageID string) *models.ModelCompletionResult {
+	return &models.ModelCompletionResult{Successful: true, Datum: &models.ModelDatum{StageRef: &models.ModelStageRefDatum{StageID: stageID}}}
+}
+
+func closure(id string) *models.ModelBlobDatum {
+	return &models.ModelBlobDatum{BlobID: id}
+}
+
+func returns(r *models.ModelCompletionResult) continuation {
+	return func(*Engine, string, []*models.ModelCompletionResult) *models.ModelCompletionResult {
+		return r
+	}
+}
+
+func mustAdd(t *testing.T, e *Engine, flowID string, op models.ModelCompletionOperation, c *models.ModelBlobDatum, deps ...string) string {
+	t.Helper()
+	sid, err := e.AddStage(flowID, op, c, deps, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sid
+}
+
+func mustValue(t *testing.T, e *Engine, flowID string, r *models.ModelCompletionResult) string {
+	t.Helper()
+	sid, err := e.AddValue(flowID, r, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sid
+}
+
+func mustComplete(t *testing.T, e *Engine, flowID string, stageID string, r *models.ModelCompletionResult) {
+	t.Helper()
+	if ok, err := e.Complete(flowID, stageID, r); err != nil || !ok {
+		t.Fatalf("got %v %v completing stage %s", ok, err, stageID)
+	}
+}
+
+// await returns the result of the stage, failing the test if it doesn't
+// complete in time
+func await(t *testing.T, e *Engine, flowID string, stageID string) *models.ModelCompletionResult {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r, err := e.Await(ctx, flowID, stageID)
+	if err != nil {
+		t.Fatalf("stage %s: %v", stageID, err)
+	}
+	return r
+}
+
+// assertPending fails the test if the stage completes shortly
+func assertPending(t *testing.T, e *Engine, flowID string, stageID string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if r, err := e.Await(ctx, flowID, stageID); err == nil {
+		t.Fatalf("stage %s completed early with %v", stageID, describe(r))
+	}
+}
+
+func describe(r *models.ModelCompletionResult) string {
+	switch {
+	case r.Datum.Blob != nil:
+		return fmt.Sprintf("%v %s", r.Successful, r.Datum.Blob.BlobID)
+	case r.Datum.Error != nil:
+		return fmt.Sprintf("%v %s", r.Successful, r.Datum.Error.Type)
+	case r.Datum.StageRef != nil:
+		return fmt.Sprintf("%v ref %s", r.Successful, r.Datum.StageRef.StageID)
+	case r.Datum.Empty != nil:
+		return fmt.Sprintf("%v empty", r.Successful)
+	}
+	return fmt.Sprintf("%v", r.Successful)
+}
+
+func assertResult(t *testing.T, got *models.ModelCompletionResult, want *models.ModelCompletionResult) {
+	t.Helper()
+	if describe(got) != describe(want) {
+		t.Errorf("got result %s, want %s", describe(got), describe(want))
+	}
+}
+
+func TestThenComposeCompletesWithComposedStage(t *testing.T) {
+	inner := make(chan string, 1)
+	e, _ := newTestEngine(map[string]continuation{
+		"compose": func(e *Engine, flowID string, args []*models.ModelCompletionResult) *models.ModelCompletionResult {
+			sid, err := e.AddStage(flowID, models.ModelCompletionOperationExternalCompletion, nil, nil, "", "")
+			if err != nil {
+				return ErrorResult(models.ModelErrorDatumTypeStageFailed, err.Error())
+			}
+			inner <- sid
+			return stageRef(sid)
+		},
+	})
+	flowID := e.CreateFlow("fn")
+	v := mustValue(t, e, flowID, value("a"))
+	composed := mustAdd(t, e, flowID, models.ModelCompletionOperationThenCompose, closure("compose"), v)
+
+	assertPending(t, e, flowID, composed)
+	mustComplete(t, e, flowID, <-inner, value("b"))
+	assertResult(t, await(t, e, flowID, composed), value("b"))
+}
+
+func TestThenComposeOfFailedStage(t *testing.T) {
+	e, inv := newTestEngine(map[string]continuation{"compose": returns(stageRef("0"))})
+	flowID := e.CreateFlow("fn")
+	failed := mustValue(t, e, flowID, failure("boom"))
+	composed := mustAdd(t, e, flowID, models.ModelCompletionOperationThenCompose, closure("compose"), failed)
+
+	assertResult(t, await(t, e, flowID, composed), failure("boom"))
+	if _, called := inv.args(composed); called {
+		t.Error("invoked the continuation of a failed stage")
+	}
+}
+
+func TestExceptionallyComposeCompletesWithComposedStage(t *testing.T) {
+	e, _ := newTestEngine(map[string]continuation{"recover": returns(stageRef("0"))})
+	flowID := e.CreateFlow("fn")
+	if recovered := mustValue(t, e, flowID, value("recovered")); recovered != "0" {
+		t.Fatalf("got stage %s, want the recovered value to be stage 0", recovered)
+	}
+	failed := mustValue(t, e, flowID, failure("boom"))
+	composed := mustAdd(t, e, flowID, models.ModelCompletionOperationExceptionallyCompose, closure("recover"), failed)
+
+	assertResult(t, await(t, e, flowID, composed), value("recovered"))
+}
+
+func TestExceptionally(t *testing.T) {
+	e, inv := newTestEngine(map[string]continuation{"recover": returns(value("recovered"))})
+	flowID := e.CreateFlow("fn")
+
+	failed := mustValue(t, e, flowID, failure("boom"))
+	recovered := mustAdd(t, e, flowID, models.ModelCompletionOperationExceptionally, closure("recover"), failed)
+	assertResult(t, await(t, e, flowID, recovered), value("recovered"))
+	args, _ := inv.args(recovered)
+	if len(args) != 1 || describe(args[0]) != describe(failure("boom")) {
+		t.Errorf("got args %v, want the failure", args)
+	}
+
+	succeeded := mustValue(t, e, flowID, value("a"))
+	passed := mustAdd(t, e, flowID, models.ModelCompletionOperationExceptionally, closure("recover"), succeeded)
+	assertResult(t, await(t, e, flowID, passed), value("a"))
+	if _, called := inv.args(passed); called {
+		t.Error("invoked exceptionally for a successful stage")
+	}
+}
+
+func TestHandle(t *testing.T) {
+	e, inv := newTestEngine(map[string]continuation{"handle": returns(value("handled"))})
+	flowID := e.CreateFlow("fn")
+
+	tests := []struct {
+		name string
+		dep  *models.ModelCompletionResult
+		args []*models.ModelCompletionResult
+	}{
+		{"success", value("a"), []*models.ModelCompletionResult{value("a"), EmptyResult()}},
+		{"failure", failure("boom"), []*models.ModelCompletionResult{EmptyResult(), failure("boom")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dep := mustValue(t, e, flowID, tt.dep)
+			handled := mustAdd(t, e, flowID, models.ModelCompletionOperationHandle, closure("handle"), dep)
+			assertResult(t, await(t, e, flowID, handled), value("handled"))
+
+			args, _ := inv.args(handled)
+			if len(args) != len(tt.args) {
+				t.Fatalf("got %d args, want %d", len(args), len(tt.args))
+			}
+			for i := range args {
+				assertResult(t, args[i], tt.args[i])
+			}
+		})
+	}
+}
+
+func TestAllOfCompletesOnceAllDependenciesComplete(t *testing.T) {
+	e, _ := newTestEngine(nil)
+	flowID := e.CreateFlow("fn")
+	var deps []string
+	for i := 0; i < 3; i++ {
+		deps = append(deps, mustAdd(t, e, flowID, models.ModelCompletionOperationExternalCompletion, nil))
+	}
+	all := mustAdd(t, e, flowID, models.ModelCompletionOperationAllOf, nil, deps...)
+
+	// complete out of order
+	for _, i := range []int{2, 0} {
+		mustComplete(t, e, flowID, deps[i], value(deps[i]))
+		assertPending(t, e, flowID, all)
+	}
+	mustComplete(t, e, flowID, deps[1], value(deps[1]))
+	assertResult(t, await(t, e, flowID, all), EmptyResult())
+}
+
+func TestAllOfFailsWithFirstFailedDependency(t *testing.T) {
+	e, _ := newTestEngine(nil)
+	flowID := e.CreateFlow("fn")
+	a := mustAdd(t, e, flowID, models.ModelCompletionOperationExternalCompletion, nil)
+	b := mustAdd(t, e, flowID, models.ModelCompletionOperationExternalCompletion, nil)
+	c := mustAdd(t, e, flowID, models.ModelCompletionOperationExternalCompletion, nil)
+	all := mustAdd(t, e, flowID, models.ModelCompletionOperationAllOf, nil, a, b, c)
+
+	// a failure doesn't complete allOf until all its dependencies complete
+	mustComplete(t, e, flowID, c, failure("c"))
+	assertPending(t, e, flowID, all)
+	mustComplete(t, e, flowID, b, failure("b"))
+	mustComplete(t, e, flowID, a, value("a"))
+	assertResult(t, await(t, e, flowID, all), failure("b"))
+}
+
+func TestAllOfWithoutDependencies(t *testing.T) {
+	e, _ := newTestEngine(nil)
+	flowID := e.CreateFlow("fn")
+	all := mustAdd(t, e, flowID, models.ModelCompletionOperationAllOf, nil)
+	assertResult(t, await(t, e, flowID, all), EmptyResult())
+}
+
+func TestAnyOfCompletesWithFirstCompletedDependency(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  *models.ModelCompletionResult
+		second *models.ModelCompletionResult
+	}{
+		{"success", value("b"), failure("a")},
+		{"failure", failure("b"), value("a")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _ := newTestEngine(nil)
+			flowID := e.CreateFlow("fn")
+			a := mustAdd(t, e, flowID, models.ModelCompletionOperationExternalCompletion, nil)
+			b := mustAdd(t, e, flowID, models.ModelCompletionOperationExternalCompletion, nil)
+			any := mustAdd(t, e, flowID, models.ModelCompletionOperationAnyOf, nil, a, b)
+
+			assertPending(t, e, flowID, any)
+			mustComplete(t, e, flowID, b, tt.first)
+			assertResult(t, await(t, e, flowID, any), tt.first)
+			mustComplete(t, e, flowID, a, tt.second)
+			assertResult(t, await(t, e, flowID, any), tt.first)
+		})
+	}
+}
+
+func TestAnyOfRequiresDependencies(t *testing.T) {
+	e, _ := newTestEngine(nil)
+	flowID := e.CreateFlow("fn")
+	if _, err := e.AddStage(flowID, models.ModelCompletionOperationAnyOf, nil, nil, "", ""); !errors.Is(err, ErrInvalidStage) {
+		t.Errorf("got %v, want ErrInvalidStage", err)
+	}
+}
+
+func TestSignalStages(t *testing.T) {
+	e, _ := newTestEngine(nil)
+	flowID := e.CreateFlow("fn")
+	sid, err := e.AddStage(flowID, models.ModelCompletionOperationExternalCompletion, nil, nil, "", "approved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := e.State(flowID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := state.Stages[sid]; s.Signal != "approved" {
+		t.Errorf("got signal %q, want approved", s.Signal)
+	}
+
+	if _, err := e.AddStage(flowID, models.ModelCompletionOperationSupply, closure("supply"), nil, "", "approved"); !errors.Is(err, ErrInvalidStage) {
+		t.Errorf("got %v adding a supply stage with a signal, want ErrInvalidStage", err)
+	}
+}
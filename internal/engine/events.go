@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fnproject/flow-lib-go/models"
+	"github.com/go-openapi/strfmt"
+)
+
+// eventLog is an append-only log of events that subscribers follow, which
+// is closed once no more events will be appended
+type eventLog[T any] struct {
+	events  []T
+	changed chan struct{}
+	closed  bool
+}
+
+func newEventLog[T any]() *eventLog[T] {
+	return &eventLog[T]{changed: make(chan struct{})}
+}
+
+func (l *eventLog[T]) append(event T) {
+	l.events = append(l.events, event)
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *eventLog[T]) close() {
+	l.closed = true
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// follow delivers the events of the log from index from onwards, until the
+// log is closed or ctx is done. mu guards the log.
+func follow[T any](ctx context.Context, mu *sync.Mutex, l *eventLog[T], from int) <-chan T {
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+		next := from
+		for {
+			mu.Lock()
+			var events []T
+			if next < len(l.events) {
+				events = l.events[next:]
+			}
+			changed, closed := l.changed, l.closed
+			mu.Unlock()
+
+			for _, ev := range events {
+				select {
+				case ch <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+			next += len(events)
+			if closed {
+				return
+			}
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// Events delivers the events of the flow with a sequence number greater
+// than fromSeq. The channel is closed once the flow has completed and all
+// its events have been delivered, or when ctx is done.
+func (e *Engine) Events(ctx context.Context, flowID string, fromSeq uint64) (<-chan *models.ModelGraphEvent, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	g, err := e.graph(flowID)
+	if err != nil {
+		return nil, err
+	}
+	// sequence numbers start at 1 and are contiguous
+	from := int(fromSeq)
+	if from > len(g.events.events) {
+		from = len(g.events.events)
+	}
+	return follow(ctx, &e.mu, g.events, from), nil
+}
+
+// Lifecycle delivers the creation and completion events of all flows from
+// now on, until ctx is done
+func (e *Engine) Lifecycle(ctx context.Context) <-chan *models.ModelGraphLifecycleEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return follow(ctx, &e.mu, e.lifecycle, len(e.lifecycle.events))
+}
+
+// State returns the stages of the flow with their status, dependencies
+// and signals
+func (e *Engine) State(flowID string) (*models.ModelGetGraphStateResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	g, err := e.graph(flowID)
+	if err != nil {
+		return nil, err
+	}
+	state := &models.ModelGetGraphStateResponse{
+		FlowID:     g.flowID,
+		FunctionID: g.functionID,
+		Stages:     make(models.ModelGetGraphStateResponseStages),
+	}
+	for _, s := range g.stages {
+		deps := make([]string, 0, len(s.deps))
+		for _, d := range s.deps {
+			deps = append(deps, d.id)
+		}
+		state.Stages[s.id] = models.GetGraphStateResponseStageRepresentation{
+			Dependencies: deps,
+			Signal:       s.signal,
+			Status:       s.status(),
+			Type:         string(s.op),
+		}
+	}
+	return state, nil
+}
+
+// Stage statuses reported by State
+const (
+	StatusPending    = "pending"
+	StatusRunning    = "running"
+	StatusSuccessful = "successful"
+	StatusFailed     = "failed"
+)
+
+func (s *stage) status() string {
+	switch {
+	case s.result != nil && s.result.Successful:
+		return StatusSuccessful
+	case s.result != nil:
+		return StatusFailed
+	case s.started:
+		return StatusRunning
+	}
+	return StatusPending
+}
+
+func (e *Engine) now() strfmt.DateTime {
+	return strfmt.DateTime(e.clock.Now())
+}
+
+// emit appends an event to the flow's log, and must be called with the
+// engine's lock held
+func (e *Engine) emit(g *graph, event *models.ModelGraphEvent) {
+	event.FlowID = g.flowID
+	event.Seq = uint64(len(g.events.events) + 1)
+	event.SentTs = e.now()
+	g.events.append(event)
+}
+
+func (e *Engine) emitLocked(g *graph, event *models.ModelGraphEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.emit(g, event)
+}
+
+func (e *Engine) emitLifecycle(event *models.ModelGraphLifecycleEvent) {
+	event.Seq = uint64(len(e.lifecycle.events) + 1)
+	e.lifecycle.append(event)
+}
+
+func unixMs(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -1,9 +1,13 @@
 package flow
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"io"
+	"os"
 	"reflect"
 	dbg "runtime/debug"
 
@@ -37,75 +41,150 @@ type InvokeStageResponse struct {
 	Result *models.ModelCompletionResult `json:"result,omitempty"`
 }
 
-func (in *InvokeStageRequest) invoke(codec codec) {
-	// catch panics and publish them as errors
+func (in *InvokeStageRequest) invoke(ctx context.Context, f *flow, codec codec) {
+	// failures to invoke the action are published as failed results, so
+	// the completer doesn't have to wait for an invalid stage response
+	written := false
 	defer func() {
 		if r := recover(); r != nil {
 			stack := fmt.Sprintf("%s: %s", r, dbg.Stack())
 			debug(fmt.Sprintf("Recovered from invoke error:\n %s", stack))
+			if !written {
+				in.writeResult(f, codec, nil, &ContinuationError{Reason: fmt.Sprintf("%v", r)})
+			}
 		}
 	}()
 
 	debug(fmt.Sprintf("Invoking continuation with %d args", len(in.Args)))
 
-	actionFunc := in.action()
+	blobStore := f.blobStore
+	actionFunc, err := in.action(blobStore)
+	if err == nil {
+		var args []interface{}
+		if args, err = in.decodeArgs(f, actionFunc, blobStore); err == nil {
+			var result interface{}
+			result, err = invokeFunc(ctx, actionFunc, args)
+			written = true
+			in.writeResult(f, codec, result, err)
+			return
+		}
+	}
+	written = true
+	in.writeResult(f, codec, nil, err)
+}
+
+// decodeArgs decodes the stage results passed to the action, checking they
+// match the action's signature
+func (in *InvokeStageRequest) decodeArgs(f *flow, actionFunc interface{}, blobStore blobstore.BlobStoreClient) ([]interface{}, error) {
+	key := getActionKey(unbound(actionFunc))
+	fn := reflect.TypeOf(unbound(actionFunc))
+	params := actionParams(fn)
+	if len(params)-boundCount(actionFunc) > MaxContinuationArgCount {
+		return nil, &ContinuationError{Action: key, Signature: fn.String(),
+			Reason: fmt.Sprintf("takes more than the maximum of %d arguments", MaxContinuationArgCount)}
+	}
 	argTypes := actionArgs(actionFunc)
+	if len(argTypes) > len(in.Args) {
+		return nil, &ContinuationError{Action: key, Signature: fn.String(),
+			Reason: fmt.Sprintf("expects %d arguments but the stage provided %d", len(argTypes), len(in.Args))}
+	}
 
 	var args []interface{}
-	for i, _ := range argTypes {
+	for i := range argTypes {
 		debug(fmt.Sprintf("Decoding arg of type %v", argTypes[i]))
-		args = append(args, decodeResult(in.Args[i], in.FlowID, argTypes[i], blobstore.GetBlobStore()))
+		arg, err := decodeResult(in.Args[i], f, argTypes[i], blobStore)
+		if err != nil {
+			return nil, &ContinuationError{Action: key, Signature: fn.String(),
+				Reason: fmt.Sprintf("argument %d can't be decoded as %v: %v", i, argTypes[i], err)}
+		}
+		if arg != nil && !reflect.TypeOf(arg).AssignableTo(argTypes[i]) {
+			return nil, &ContinuationError{Action: key, Signature: fn.String(),
+				Reason: fmt.Sprintf("argument %d of type %v is not assignable to %v", i, reflect.TypeOf(arg), argTypes[i])}
+		}
+		args = append(args, arg)
 	}
-
-	result, err := invokeFunc(actionFunc, args)
-	writeResult(in.FlowID, codec, result, err)
+	return args, nil
 }
 
-func (in *InvokeStageRequest) action() (actionFunction interface{}) {
-	blobstore.GetBlobStore().ReadBlob(in.FlowID, in.Closure.BlobID, JSONMediaHeader,
+func (in *InvokeStageRequest) action(blobStore blobstore.BlobStoreClient) (actionFunction interface{}, err error) {
+	readErr := blobStore.ReadBlob(in.FlowID, in.Closure.BlobID, JSONMediaHeader,
 		func(body io.ReadCloser) {
 			var ref actionRef
-			if err := json.NewDecoder(body).Decode(&ref); err != nil {
-				panic("Failed to decode continuation")
+			if err = json.NewDecoder(body).Decode(&ref); err != nil {
+				err = &ContinuationError{Reason: fmt.Sprintf("failed to decode continuation: %v", err)}
+				return
 			}
 
 			var valid bool
 			actionFunction, valid = actions[ref.ID]
 			if !valid {
-				panic("Continuation not registered")
+				err = &ContinuationError{Action: ref.ID, Reason: "action is not registered"}
+				return
+			}
+			if len(ref.Args) > 0 {
+				if actionFunction, err = ref.bind(actionFunction); err != nil {
+					err = &ContinuationError{Action: ref.ID, Signature: reflect.TypeOf(actions[ref.ID]).String(), Reason: err.Error()}
+				}
 			}
 		})
+	if readErr != nil {
+		return nil, readErr
+	}
 	return
 }
 
-func handleInvocation(codec codec) {
+func handleInvocation(ctx context.Context, f *flow, codec codec) {
 	debug("Handling continuation")
 	var in InvokeStageRequest
 	if err := json.NewDecoder(codec.in()).Decode(&in); err != nil {
 		panic(fmt.Sprintf("Failed to decode stage invocation request: %v", err))
 	}
-	in.invoke(codec)
+	in.invoke(ctx, f, codec)
 }
 
-func invokeFunc(continuation interface{}, args []interface{}) (result interface{}, err error) {
-	fn := reflect.ValueOf(continuation)
+func invokeFunc(ctx context.Context, continuation interface{}, args []interface{}) (result interface{}, err error) {
 	var rargs []reflect.Value
 	argTypes := actionArgs(continuation)
 
-	if reflect.TypeOf(continuation).NumIn() == 0 {
+	var bound []interface{}
+	if b, ok := continuation.(*boundAction); ok {
+		continuation, bound = b.action, b.args
+	}
+	fn := reflect.ValueOf(continuation)
+	params := actionParams(fn.Type())
+
+	if takesContext(fn.Type()) {
+		rargs = append(rargs, reflect.ValueOf(ctx))
+	}
+	for i, a := range bound {
+		if a == nil {
+			rargs = append(rargs, reflect.Zero(params[i]))
+		} else {
+			rargs = append(rargs, reflect.ValueOf(a))
+		}
+	}
+	if len(argTypes) == 0 {
 		debug("Ignoring arguments for empty continuation function")
-		rargs = make([]reflect.Value, 0)
 	} else {
-		rargs = make([]reflect.Value, len(args))
 		for i, a := range args {
 			if a == nil { // converts empty datum parameters to zero type
-				rargs[i] = reflect.Zero(argTypes[i])
+				rargs = append(rargs, reflect.Zero(argTypes[i]))
 			} else {
-				rargs[i] = reflect.ValueOf(a)
+				rargs = append(rargs, reflect.ValueOf(a))
 			}
 		}
 	}
 
+	// a panicking action fails its stage rather than the invocation
+	defer func() {
+		if r := recover(); r != nil {
+			stack := dbg.Stack()
+			debug(fmt.Sprintf("Recovered from panic in continuation:\n %s: %s", r, stack))
+			result = nil
+			err = &PanicError{Action: getActionKey(continuation), Value: fmt.Sprintf("%v", r), Stack: string(stack)}
+		}
+	}()
+
 	results := fn.Call(rargs)
 	switch len(results) {
 	case 0:
@@ -119,7 +198,9 @@ func invokeFunc(continuation interface{}, args []interface{}) (result interface{
 	}
 }
 
-func writeResult(flowID string, codec codec, result interface{}, err error) {
+// writeResult publishes the result of the stage. Failures to publish are
+// logged, since the completer can only learn of them from the missing response.
+func (in *InvokeStageRequest) writeResult(f *flow, codec codec, result interface{}, err error) {
 	var val interface{}
 	if err == nil {
 		debug(fmt.Sprintf("Writing successful result %v", result))
@@ -128,39 +209,171 @@ func writeResult(flowID string, codec codec, result interface{}, err error) {
 		debug(fmt.Sprintf("Writing error result %v", err))
 		val = err
 	}
-	resp := &InvokeStageResponse{Result: valueToModel(val, flowID, blobstore.GetBlobStore())}
+	blobStore := f.blobStore
+	model, modelErr := valueToModel(val, f.flowID, blobStore, f.options.contentType)
+	if _, isBlobErr := modelErr.(*blobstore.BlobStoreError); modelErr != nil && err == nil && !isBlobErr {
+		// the action's result couldn't be encoded, so fail the stage instead
+		model, modelErr = valueToModel(&ContinuationError{Reason: fmt.Sprintf("result can't be encoded: %v", modelErr)}, f.flowID, blobStore, f.options.contentType)
+	}
+	if modelErr != nil {
+		fmt.Fprintf(os.Stderr, "Failed to publish result of stage %s: %v\n", in.StageID, modelErr)
+		return
+	}
+	resp := &InvokeStageResponse{Result: model}
 	if err := json.NewEncoder(codec.out()).Encode(resp); err != nil {
-		panic("Failed to encode completion result")
+		fmt.Fprintf(os.Stderr, "Failed to encode result of stage %s: %v\n", in.StageID, err)
 	}
 }
 
-// internal encoding of a function pointer since go doesn't allow pointers to be serialized
+// internal encoding of a function pointer since go doesn't allow pointers to be serialized.
+// Bound arguments are gob-encoded individually, so they can be decoded using
+// the parameter types of the registered action.
 type actionRef struct {
-	ID string `json:"action-key"`
+	ID   string   `json:"action-key"`
+	Args [][]byte `json:"args,omitempty"`
 }
 
 func (cr *actionRef) getKey() string {
 	return cr.ID
 }
 
-func newActionRef(actionFunc interface{}) *actionRef {
-	return &actionRef{ID: getActionKey(actionFunc)}
+func newActionRef(actionFunc interface{}) (*actionRef, error) {
+	b, ok := actionFunc.(*boundAction)
+	if !ok {
+		return &actionRef{ID: getActionKey(actionFunc)}, nil
+	}
+	ref := &actionRef{ID: getActionKey(b.action)}
+	for _, arg := range b.args {
+		if arg == nil { // encoded as empty and decoded as the zero value
+			ref.Args = append(ref.Args, nil)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(arg); err != nil {
+			return nil, fmt.Errorf("Failed to encode bound argument: %v", err)
+		}
+		ref.Args = append(ref.Args, buf.Bytes())
+	}
+	return ref, nil
 }
 
+// bind decodes the bound arguments of the reference for the given action
+func (cr *actionRef) bind(actionFunc interface{}) (*boundAction, error) {
+	params := actionParams(reflect.TypeOf(actionFunc))
+	if len(cr.Args) > len(params) {
+		return nil, fmt.Errorf("Continuation %s takes %d parameters but %d arguments are bound", cr.ID, len(params), len(cr.Args))
+	}
+	b := &boundAction{action: actionFunc}
+	for i, arg := range cr.Args {
+		if params[i].Kind() == reflect.Interface {
+			return nil, fmt.Errorf("Continuation %s binds argument %d of interface type %v, which can't be decoded", cr.ID, i, params[i])
+		}
+		if len(arg) == 0 {
+			b.args = append(b.args, nil)
+			continue
+		}
+		v, err := decodeValue(gobCodec{}, bytes.NewReader(arg), params[i])
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode bound argument %d of continuation %s: %v", i, cr.ID, err)
+		}
+		b.args = append(b.args, v)
+	}
+	return b, nil
+}
+
+// an action with leading arguments captured when the stage is added
+type boundAction struct {
+	action interface{}
+	args   []interface{}
+}
+
+func unbound(actionFunc interface{}) interface{} {
+	if b, ok := actionFunc.(*boundAction); ok {
+		return b.action
+	}
+	return actionFunc
+}
+
+func boundCount(actionFunc interface{}) int {
+	if b, ok := actionFunc.(*boundAction); ok {
+		return len(b.args)
+	}
+	return 0
+}
+
+// Bind captures values as the leading arguments of a registered action, so
+// they are passed to the action ahead of any stage results when the stage
+// runs. Bound values are serialized with gob, e.g.
+//
+//	cf.Supply(flows.Bind(ResizeImage, 640))
+//
+// calls ResizeImage(640) and
+//
+//	image.ThenApply(flows.Bind(ResizeImage, 640))
+//
+// calls ResizeImage(640, image). Parameters of interface types can't be
+// bound, as their values couldn't be decoded.
+func Bind(action interface{}, args ...interface{}) interface{} {
+	var bound []interface{}
+	if b, ok := action.(*boundAction); ok {
+		action, bound = b.action, b.args
+	}
+	if reflect.TypeOf(action) == nil || reflect.TypeOf(action).Kind() != reflect.Func {
+		panic("Action must be a function!")
+	}
+	bound = append(append([]interface{}{}, bound...), args...)
+	params := actionParams(reflect.TypeOf(action))
+	if len(bound) > len(params) {
+		panic(fmt.Sprintf("Action takes %d parameters but %d arguments were bound", len(params), len(bound)))
+	}
+	for i, arg := range bound {
+		if params[i].Kind() == reflect.Interface {
+			panic(fmt.Sprintf("Cannot bind argument %d of interface type %v, as its concrete type can't be decoded", i, params[i]))
+		}
+		if arg != nil && !reflect.TypeOf(arg).AssignableTo(params[i]) {
+			panic(fmt.Sprintf("Bound argument %d of type %v is not assignable to %v", i, reflect.TypeOf(arg), params[i]))
+		}
+	}
+	return &boundAction{action: action, args: bound}
+}
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// actions may declare a leading context.Context parameter, which receives
+// the invocation context so the action can retrieve its flow with FromContext
+func takesContext(fn reflect.Type) bool {
+	return fn.NumIn() > 0 && fn.In(0) == contextType
+}
+
+// actionParams returns the parameter types of an action function,
+// excluding any leading context.Context parameter
+func actionParams(fn reflect.Type) []reflect.Type {
+	offset := 0
+	if takesContext(fn) {
+		offset = 1
+	}
+	params := make([]reflect.Type, fn.NumIn()-offset)
+	for i := range params {
+		params[i] = fn.In(i + offset)
+	}
+	return params
+}
+
+// actionArgs returns the types of the stage results an action receives,
+// i.e. its parameters following any context or bound arguments
 func actionArgs(actionFunc interface{}) (argTypes []reflect.Type) {
-	if reflect.TypeOf(actionFunc).Kind() != reflect.Func {
+	var bound int
+	if b, ok := actionFunc.(*boundAction); ok {
+		actionFunc, bound = b.action, len(b.args)
+	}
+	if actionFunc == nil || reflect.TypeOf(actionFunc).Kind() != reflect.Func {
 		panic("Continuation must be a function!")
 	}
 
-	fn := reflect.TypeOf(actionFunc)
-	argC := fn.NumIn() // inbound params
-	if argC > MaxContinuationArgCount {
+	argTypes = actionParams(reflect.TypeOf(actionFunc))[bound:]
+	if len(argTypes) > MaxContinuationArgCount {
 		panic(fmt.Sprintf("Continuations may take a maximum of %d parameters", MaxContinuationArgCount))
 	}
-	argTypes = make([]reflect.Type, argC)
-	for i := 0; i < argC; i++ {
-		argTypes[i] = fn.In(i)
-	}
 	return
 }
 
@@ -0,0 +1,160 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fnproject/flow-lib-go/blobstore"
+)
+
+func resizeAction(width int, label string, image []byte) string {
+	return fmt.Sprintf("%s %d %d", label, width, len(image))
+}
+
+func stringerAction(s fmt.Stringer, image []byte) string {
+	return s.String()
+}
+
+func TestBindRoundTrip(t *testing.T) {
+	ref, err := newActionRef(Bind(resizeAction, 640, "thumb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ref.bind(resizeAction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(b.args, []interface{}{640, "thumb"}) {
+		t.Errorf("got bound arguments %v, want [640 thumb]", b.args)
+	}
+}
+
+func TestBindRejectsInterfaceParams(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("binding an interface parameter didn't panic")
+		}
+	}()
+	Bind(stringerAction, StageRef{FlowID: "flow", StageID: "1"})
+}
+
+func TestActionRefBindRejectsInterfaceParams(t *testing.T) {
+	// e.g. a reference bound before the action's signature changed
+	ref := &actionRef{ID: "stringer", Args: [][]byte{[]byte("encoded")}}
+	if _, err := ref.bind(stringerAction); err == nil {
+		t.Error("got no error binding an interface parameter")
+	}
+}
+
+func labelAction(width int, label string) string {
+	return fmt.Sprintf("%s %d", label, width)
+}
+
+func init() {
+	if err := RegisterActionAs("test.label", labelAction); err != nil {
+		panic(err)
+	}
+}
+
+// invokeStage invokes a stage with a closure blob holding closure, or no
+// closure if it's nil, and returns the stage's failure
+func invokeStage(t *testing.T, closure []byte, args ...interface{}) error {
+	t.Helper()
+	store := blobstore.NewMemoryBlobStore()
+	f := &flow{blobStore: store, flowID: "flow", options: &flowOptions{contentType: GobMediaHeader}}
+	in := &InvokeStageRequest{FlowID: "flow", StageID: "1"}
+	if closure != nil {
+		b, err := store.WriteBlob("flow", JSONMediaHeader, bytes.NewReader(closure))
+		if err != nil {
+			t.Fatal(err)
+		}
+		in.Closure = b.BlobDatum()
+	}
+	for _, arg := range args {
+		result, err := valueToModel(arg, "flow", store, GobMediaHeader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in.Args = append(in.Args, result)
+	}
+
+	var out bytes.Buffer
+	in.invoke(context.Background(), f, newCodec(context.Background(), nil, &out))
+	var resp InvokeStageResponse
+	if err := json.NewDecoder(&out).Decode(&resp); err != nil {
+		t.Fatalf("got no stage result: %v", err)
+	}
+	if resp.Result.Successful {
+		t.Fatal("got a successful stage result")
+	}
+	failure, err := datumToError(resp.Result.Datum.InnerDatum(), "flow", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return failure
+}
+
+func closureJSON(t *testing.T, key string, args ...interface{}) []byte {
+	ref := &actionRef{ID: key}
+	for _, arg := range args {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(arg); err != nil {
+			t.Fatal(err)
+		}
+		ref.Args = append(ref.Args, buf.Bytes())
+	}
+	b, err := json.Marshal(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestContinuationError(t *testing.T) {
+	signature := reflect.TypeOf(labelAction).String()
+	tests := []struct {
+		name      string
+		closure   []byte
+		args      []interface{}
+		action    string
+		signature string
+		reason    string
+	}{
+		{"unregistered action", closureJSON(t, "test.missing"), []interface{}{1, "x"},
+			"test.missing", "", "action is not registered"},
+		{"arity mismatch", closureJSON(t, "test.label"), []interface{}{1},
+			"test.label", signature, "expects 2 arguments but the stage provided 1"},
+		{"bound argument type mismatch", closureJSON(t, "test.label", "wide"), []interface{}{"x"},
+			"test.label", signature, "Failed to decode bound argument 0"},
+		{"undecodable continuation", []byte("not json"), nil,
+			"", "", "failed to decode continuation"},
+		// a request without a closure panics, which is recovered
+		{"recovered panic", nil, nil,
+			"", "", "nil pointer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := invokeStage(t, tt.closure, tt.args...)
+			var contErr *ContinuationError
+			if !errors.As(err, &contErr) {
+				t.Fatalf("got %T %v, want a ContinuationError", err, err)
+			}
+			if contErr.Action != tt.action {
+				t.Errorf("got action %q, want %q", contErr.Action, tt.action)
+			}
+			if contErr.Signature != tt.signature {
+				t.Errorf("got signature %q, want %q", contErr.Signature, tt.signature)
+			}
+			if !strings.Contains(contErr.Reason, tt.reason) {
+				t.Errorf("got reason %q, want it to contain %q", contErr.Reason, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package flow
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+const defaultRetainCompleted = 1000
+
+// statusTimeout bounds how long a LifecycleWatcher spends reading the final
+// status of a completed flow
+var statusTimeout = 10 * time.Second
+
+// FlowInfo describes a flow seen by a LifecycleWatcher
+type FlowInfo struct {
+	FlowID     string
+	FunctionID string
+	Created    time.Time // zero if the flow was created before the watcher started
+	Completed  time.Time // zero while the flow is active
+	// Status is the final status of a completed flow, or empty while it is
+	// active. It is ModelStatusDatumTypeUnknownState if it couldn't be read
+	// from the flow's events in time.
+	Status models.ModelStatusDatumType
+}
+
+// Active reports whether the flow hasn't completed
+func (f FlowInfo) Active() bool {
+	return f.Completed.IsZero()
+}
+
+// LifecycleWatcher keeps an index of the active and recently completed flows
+// of the flow service at COMPLETER_BASE_URL, following the creation and
+// completion of every flow. Flows created before the watcher started are
+// only known once they complete.
+type LifecycleWatcher struct {
+	base   *url.URL
+	retain int
+
+	mu          sync.Mutex
+	flows       map[string]*FlowInfo
+	completed   []string // IDs of completed flows, oldest first
+	onCompleted []func(FlowInfo)
+	done        chan struct{}
+}
+
+type LifecycleOption func(*LifecycleWatcher)
+
+// WithRetainCompleted sets how many completed flows are kept in the index,
+// dropping the oldest first. The default is 1000.
+func WithRetainCompleted(n int) LifecycleOption {
+	return func(w *LifecycleWatcher) {
+		w.retain = n
+	}
+}
+
+// WithOnCompleted calls fn with each flow that completes, see OnCompleted
+func WithOnCompleted(fn func(FlowInfo)) LifecycleOption {
+	return func(w *LifecycleWatcher) {
+		w.onCompleted = append(w.onCompleted, fn)
+	}
+}
+
+// WatchLifecycle starts following the lifecycle of all flows until ctx is
+// done. An error is returned if the flow service's lifecycle stream can't
+// be opened; later disconnections are retried, though flows created and
+// completed while disconnected are missed.
+func WatchLifecycle(ctx context.Context, opts ...LifecycleOption) (*LifecycleWatcher, error) {
+	base, err := CompleterURL()
+	if err != nil {
+		return nil, err
+	}
+	w := &LifecycleWatcher{
+		base:   base,
+		retain: defaultRetainCompleted,
+		flows:  make(map[string]*FlowInfo),
+		done:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	events, err := WatchLifecycleEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer close(w.done)
+		// completions are applied in the order they happen, each once the
+		// previous one has been applied, though their statuses are read
+		// concurrently so the events that follow aren't held up
+		var last <-chan struct{}
+		for ev := range events {
+			switch {
+			case ev.GraphCreated != nil:
+				w.addCreated(ev)
+			case ev.GraphCompleted != nil:
+				applied := make(chan struct{})
+				go func(ev *models.ModelGraphLifecycleEvent, prev <-chan struct{}) {
+					defer close(applied)
+					status := w.finalStatus(ctx, ev.FlowID)
+					if prev != nil {
+						<-prev
+					}
+					w.addCompleted(ev, status)
+				}(ev, last)
+				last = applied
+			}
+		}
+		if last != nil {
+			<-last
+		}
+	}()
+	return w, nil
+}
+
+// WatchLifecycleEvents delivers the creation and completion events of all
+// flows as they happen. If the stream drops it is reconnected, though
+// events sent while disconnected are missed. The channel is closed when ctx
+// is done or reconnecting fails with a non-retryable error.
+func WatchLifecycleEvents(ctx context.Context) (<-chan *models.ModelGraphLifecycleEvent, error) {
+	base, err := CompleterURL()
+	if err != nil {
+		return nil, err
+	}
+	streamURL := func() string {
+		return strings.TrimSuffix(base.String(), "/") + "/v1/stream"
+	}
+	// the lifecycle stream can't be resumed, so a reconnected stream only
+	// delivers new events, whose sequence may restart with the service
+	return watch(ctx, "stream flow lifecycle", streamURL, func(*models.ModelGraphLifecycleEvent) bool {
+		return true
+	})
+}
+
+func (w *LifecycleWatcher) addCreated(ev *models.ModelGraphLifecycleEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.flows[ev.FlowID]; ok {
+		return
+	}
+	w.flows[ev.FlowID] = &FlowInfo{
+		FlowID:     ev.FlowID,
+		FunctionID: ev.GraphCreated.FunctionID,
+		Created:    time.Time(ev.GraphCreated.Ts),
+	}
+}
+
+func (w *LifecycleWatcher) addCompleted(ev *models.ModelGraphLifecycleEvent, status models.ModelStatusDatumType) {
+	w.mu.Lock()
+	info, ok := w.flows[ev.FlowID]
+	if ok && !info.Active() {
+		// e.g. delivered again by the flow service
+		w.mu.Unlock()
+		return
+	}
+	if !ok {
+		info = &FlowInfo{FlowID: ev.FlowID, FunctionID: ev.GraphCompleted.FunctionID}
+		w.flows[ev.FlowID] = info
+	}
+	info.Completed = time.Time(ev.GraphCompleted.Ts)
+	info.Status = status
+	w.completed = append(w.completed, ev.FlowID)
+	for len(w.completed) > w.retain {
+		delete(w.flows, w.completed[0])
+		w.completed = w.completed[1:]
+	}
+	completed := *info
+	callbacks := w.onCompleted
+	w.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn(completed)
+	}
+}
+
+// finalStatus reads the status of a completed flow from its events
+func (w *LifecycleWatcher) finalStatus(ctx context.Context, flowID string) models.ModelStatusDatumType {
+	ctx, cancel := context.WithTimeout(ctx, statusTimeout)
+	defer cancel()
+	op := "read flow status"
+	body, err := openStream(ctx, streamHTTPClient(), op, flowStreamURL(w.base.String(), flowID, 0))
+	if err != nil {
+		debug(fmt.Sprintf("Failed to %s of %s: %v", op, flowID, err))
+		return models.ModelStatusDatumTypeUnknownState
+	}
+	defer body.Close()
+
+	r := bufio.NewReader(body)
+	for {
+		line, err := readStreamLine(r)
+		if err != nil {
+			debug(fmt.Sprintf("Failed to %s of %s: %v", op, flowID, err))
+			return models.ModelStatusDatumTypeUnknownState
+		}
+		ev, err := decodeStreamEvent[*models.ModelGraphEvent](line)
+		if err != nil {
+			debug(fmt.Sprintf("Skipping event of %s: %v", flowID, err))
+			continue
+		}
+		if ev.GraphTerminating != nil {
+			return ev.GraphTerminating.Status
+		}
+		if ev.GraphCompleted != nil {
+			return models.ModelStatusDatumTypeUnknownState
+		}
+	}
+}
+
+// OnCompleted calls fn with each flow that completes from now on, in the
+// order they complete. Callbacks are called one at a time and delay the
+// handling of later completions, so shouldn't block.
+func (w *LifecycleWatcher) OnCompleted(fn func(FlowInfo)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onCompleted = append(w.onCompleted, fn)
+}
+
+// Flow returns the flow with the given ID, if it is active or recently
+// completed
+func (w *LifecycleWatcher) Flow(flowID string) (FlowInfo, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if info, ok := w.flows[flowID]; ok {
+		return *info, true
+	}
+	return FlowInfo{}, false
+}
+
+// Active returns the flows that haven't completed, oldest first
+func (w *LifecycleWatcher) Active() []FlowInfo {
+	return w.query(FlowInfo.Active)
+}
+
+// Completed returns the recently completed flows, oldest first
+func (w *LifecycleWatcher) Completed() []FlowInfo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	flows := make([]FlowInfo, 0, len(w.completed))
+	for _, flowID := range w.completed {
+		flows = append(flows, *w.flows[flowID])
+	}
+	return flows
+}
+
+// Failed returns the recently completed flows that didn't succeed, oldest
+// first
+func (w *LifecycleWatcher) Failed() []FlowInfo {
+	return w.query(func(f FlowInfo) bool {
+		return !f.Active() && f.Status != models.ModelStatusDatumTypeSucceeded
+	})
+}
+
+// ByFunction returns the active and recently completed flows created by
+// the given function, oldest first
+func (w *LifecycleWatcher) ByFunction(functionID string) []FlowInfo {
+	return w.query(func(f FlowInfo) bool {
+		return f.FunctionID == functionID
+	})
+}
+
+func (w *LifecycleWatcher) query(match func(FlowInfo) bool) []FlowInfo {
+	w.mu.Lock()
+	var flows []FlowInfo
+	for _, info := range w.flows {
+		if match(*info) {
+			flows = append(flows, *info)
+		}
+	}
+	w.mu.Unlock()
+
+	sort.Slice(flows, func(i, j int) bool {
+		if !flows[i].Created.Equal(flows[j].Created) {
+			return flows[i].Created.Before(flows[j].Created)
+		}
+		return flows[i].FlowID < flows[j].FlowID
+	})
+	return flows
+}
+
+// Done is closed once the watcher stops following the flow service, when
+// its context is done or the lifecycle stream can't be reconnected
+func (w *LifecycleWatcher) Done() <-chan struct{} {
+	return w.done
+}
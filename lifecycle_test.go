@@ -0,0 +1,194 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+// lifecycleServer serves a lifecycle stream of the given lines, and the
+// event streams of flows ending with the status in statuses, or never
+// ending if it is empty
+func lifecycleServer(t *testing.T, lines []string, statuses map[string]models.ModelStatusDatumType) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/stream" {
+			for _, line := range lines {
+				fmt.Fprintln(w, line)
+			}
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		flowID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/flows/"), "/stream")
+		status, ok := statuses[flowID]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if status == "" {
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		fmt.Fprintf(w, `{"result":{"seq":1,"flow_id":%q,"graph_terminating":{"status":%q}}}`+"\n", flowID, status)
+		fmt.Fprintf(w, `{"result":{"seq":2,"flow_id":%q,"graph_completed":{}}}`+"\n", flowID)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("COMPLETER_BASE_URL", srv.URL)
+}
+
+func createdLine(seq int, flowID string) string {
+	return fmt.Sprintf(`{"result":{"seq":%d,"flow_id":%q,"graph_created":{"flow_id":%q,"function_id":"app/fn"}}}`, seq, flowID, flowID)
+}
+
+func completedLine(seq int, flowID string) string {
+	return fmt.Sprintf(`{"result":{"seq":%d,"flow_id":%q,"graph_completed":{"flow_id":%q,"function_id":"app/fn","ts":"2020-01-01T00:00:00.000Z"}}}`, seq, flowID, flowID)
+}
+
+// watchCompletions returns a watcher and a channel of the flows it sees
+// complete
+func watchCompletions(t *testing.T, opts ...LifecycleOption) (*LifecycleWatcher, <-chan FlowInfo) {
+	ctx, cancel := context.WithCancel(context.Background())
+	completions := make(chan FlowInfo, 10)
+	opts = append(opts, WithOnCompleted(func(info FlowInfo) { completions <- info }))
+	w, err := WatchLifecycle(ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		<-w.Done()
+	})
+	return w, completions
+}
+
+func nextCompletion(t *testing.T, completions <-chan FlowInfo) FlowInfo {
+	t.Helper()
+	select {
+	case info := <-completions:
+		return info
+	case <-time.After(10 * time.Second):
+		t.Fatal("no flow completed")
+		return FlowInfo{}
+	}
+}
+
+func TestLifecycleIgnoresRepeatedCompletion(t *testing.T) {
+	lifecycleServer(t, []string{
+		createdLine(1, "a"),
+		completedLine(2, "a"),
+		completedLine(0, "a"), // unsequenced, so not deduplicated by the stream
+		createdLine(3, "b"),
+		completedLine(4, "b"),
+	}, map[string]models.ModelStatusDatumType{
+		"a": models.ModelStatusDatumTypeSucceeded,
+		"b": models.ModelStatusDatumTypeFailed,
+	})
+	w, completions := watchCompletions(t, WithRetainCompleted(1))
+
+	if info := nextCompletion(t, completions); info.FlowID != "a" || info.Status != models.ModelStatusDatumTypeSucceeded {
+		t.Errorf("got %+v, want a succeeded", info)
+	}
+	if info := nextCompletion(t, completions); info.FlowID != "b" || info.Status != models.ModelStatusDatumTypeFailed {
+		t.Errorf("got %+v, want b failed", info)
+	}
+	completed := w.Completed()
+	if len(completed) != 1 || completed[0].FlowID != "b" {
+		t.Errorf("got completed %+v, want only b", completed)
+	}
+	if failed := w.Failed(); len(failed) != 1 || failed[0].FlowID != "b" {
+		t.Errorf("got failed %+v, want only b", failed)
+	}
+	if _, ok := w.Flow("a"); ok {
+		t.Error("got flow a, which should have been dropped")
+	}
+}
+
+func TestLifecycleReadsStatusAsynchronously(t *testing.T) {
+	defer func(d time.Duration) { statusTimeout = d }(statusTimeout)
+	statusTimeout = 500 * time.Millisecond
+
+	lifecycleServer(t, []string{
+		createdLine(1, "slow"),
+		completedLine(2, "slow"),
+		createdLine(3, "fast"),
+		completedLine(4, "fast"),
+		createdLine(5, "active"),
+	}, map[string]models.ModelStatusDatumType{
+		"slow": "",
+		"fast": models.ModelStatusDatumTypeSucceeded,
+	})
+	w, completions := watchCompletions(t)
+
+	// later events are handled while the slow flow's status is being read
+	deadline := time.Now().Add(statusTimeout / 2)
+	for {
+		if _, ok := w.Flow("active"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("reading a flow's status held up later events")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// completions are still reported in order, once the slow read times out
+	if info := nextCompletion(t, completions); info.FlowID != "slow" || info.Status != models.ModelStatusDatumTypeUnknownState {
+		t.Errorf("got %+v, want slow with an unknown status", info)
+	}
+	if info := nextCompletion(t, completions); info.FlowID != "fast" || info.Status != models.ModelStatusDatumTypeSucceeded {
+		t.Errorf("got %+v, want fast succeeded", info)
+	}
+	if active := w.Active(); len(active) != 1 || active[0].FlowID != "active" {
+		t.Errorf("got active %+v, want only active", active)
+	}
+}
+
+func TestWatchLifecycleEventsAfterSequenceRestarts(t *testing.T) {
+	var mu sync.Mutex
+	var conns int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		conns++
+		conn := conns
+		mu.Unlock()
+		switch conn {
+		case 1:
+			fmt.Fprintln(w, createdLine(1, "a"))
+			fmt.Fprintln(w, createdLine(2, "b"))
+		default:
+			// e.g. the flow service restarted
+			fmt.Fprintln(w, createdLine(1, "c"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("COMPLETER_BASE_URL", srv.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	evs, err := WatchLifecycleEvents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var flows []string
+	for len(flows) < 3 {
+		select {
+		case ev := <-evs:
+			flows = append(flows, ev.FlowID)
+		case <-ctx.Done():
+			t.Fatalf("got events of flows %v, want a, b and c", flows)
+		}
+	}
+	if fmt.Sprint(flows) != "[a b c]" {
+		t.Errorf("got events of flows %v, want [a b c]", flows)
+	}
+}
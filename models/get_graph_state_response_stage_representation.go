@@ -19,6 +19,9 @@ type GetGraphStateResponseStageRepresentation struct {
 	// dependencies
 	Dependencies []string `json:"dependencies"`
 
+	// name of the signal an externalCompletion stage waits for
+	Signal string `json:"signal,omitempty"`
+
 	// status
 	Status string `json:"status,omitempty"`
 
@@ -33,6 +33,9 @@ type ModelAddStageRequest struct {
 
 	// operation
 	Operation ModelCompletionOperation `json:"operation,omitempty"`
+
+	// name of the signal an externalCompletion stage waits for
+	Signal string `json:"signal,omitempty"`
 }
 
 // Validate validates this model add stage request
@@ -0,0 +1,67 @@
+package flow_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	fdk "github.com/fnproject/fdk-go"
+	flow "github.com/fnproject/flow-lib-go"
+	"github.com/fnproject/flow-lib-go/flowtest"
+)
+
+// panicFailures receives the failures seen by stages depending on a
+// panicking stage
+var panicFailures = make(chan error, 1)
+
+func panickingAction() string {
+	panic("out of cheese")
+}
+
+func recordPanicFailure(err error) string {
+	panicFailures <- err
+	return ""
+}
+
+func init() {
+	if err := flow.RegisterActionAs("panicking", panickingAction); err != nil {
+		panic(err)
+	}
+	if err := flow.RegisterAction(recordPanicFailure); err != nil {
+		panic(err)
+	}
+}
+
+func TestPanicError(t *testing.T) {
+	h := flowtest.New(flow.WithFlow(fdk.HandlerFunc(func(ctx context.Context, in io.Reader, out io.Writer) {
+		flow.FromContext(ctx).Supply(panickingAction).Exceptionally(recordPanicFailure)
+	})))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := h.Invoke(ctx, strings.NewReader("")).Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	select {
+	case err = <-panicFailures:
+	default:
+		t.Fatal("the panicking stage's failure wasn't handled")
+	}
+	var panicErr *flow.PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("got %T %v, want a PanicError", err, err)
+	}
+	if panicErr.Action != "panicking" {
+		t.Errorf("got action %q, want %q", panicErr.Action, "panicking")
+	}
+	if panicErr.Value != "out of cheese" {
+		t.Errorf("got value %q, want %q", panicErr.Value, "out of cheese")
+	}
+	if !strings.Contains(panicErr.Stack, "panickingAction") {
+		t.Errorf("got stack %q, want it to include the panicking action", panicErr.Stack)
+	}
+}
@@ -0,0 +1,94 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// StageRef identifies a stage of a flow outside of the flow, e.g. for a
+// service to complete an EmptyFuture with CompleteExternally. It encodes
+// as a JSON object, or as a string with String and ParseStageRef.
+type StageRef struct {
+	FlowID  string `json:"flow_id"`
+	StageID string `json:"stage_id"`
+}
+
+// String returns the reference as "<flow id>/<stage id>"
+func (r StageRef) String() string {
+	return r.FlowID + "/" + r.StageID
+}
+
+// ParseStageRef parses a reference returned by StageRef.String
+func ParseStageRef(s string) (StageRef, error) {
+	i := strings.LastIndex(s, "/")
+	if i <= 0 || i == len(s)-1 {
+		return StageRef{}, fmt.Errorf("Invalid stage reference %q", s)
+	}
+	return StageRef{FlowID: s[:i], StageID: s[i+1:]}, nil
+}
+
+// Ref returns a reference to the future's stage, which is empty if the
+// stage couldn't be added to the flow
+func (f *flowFuture) Ref() StageRef {
+	if f.err != nil {
+		return StageRef{}
+	}
+	return StageRef{FlowID: f.flowID, StageID: f.stageID}
+}
+
+// CompleteExternally completes the referenced stage with a value, encoded
+// as it would be by the flow's own Complete, using the flow service at
+// COMPLETER_BASE_URL. It returns false if the stage was already complete.
+// Options select the codec of the value, as for WithFlow.
+func CompleteExternally(ref StageRef, value interface{}, opts ...FlowOption) (bool, error) {
+	return completeExternally(context.Background(), ref, value, newCodeLoc(), opts)
+}
+
+// CompleteExternallyContext is CompleteExternally with a context that
+// cancels the request
+func CompleteExternallyContext(ctx context.Context, ref StageRef, value interface{}, opts ...FlowOption) (bool, error) {
+	return completeExternally(ctx, ref, value, newCodeLoc(), opts)
+}
+
+// FailExternally completes the referenced stage with an error, as
+// CompleteExternally does with a value
+func FailExternally(ref StageRef, err error, opts ...FlowOption) (bool, error) {
+	return failExternally(context.Background(), ref, err, newCodeLoc(), opts)
+}
+
+// FailExternallyContext is FailExternally with a context that cancels the
+// request
+func FailExternallyContext(ctx context.Context, ref StageRef, err error, opts ...FlowOption) (bool, error) {
+	return failExternally(ctx, ref, err, newCodeLoc(), opts)
+}
+
+func failExternally(ctx context.Context, ref StageRef, err error, loc *codeLoc, opts []FlowOption) (bool, error) {
+	if err == nil {
+		return false, errors.New("Cannot fail a stage with a nil error")
+	}
+	return complete(ctx, ref, err, loc, opts)
+}
+
+func completeExternally(ctx context.Context, ref StageRef, value interface{}, loc *codeLoc, opts []FlowOption) (bool, error) {
+	if _, isErr := value.(error); isErr {
+		return false, errors.New("Use FailExternally to complete a stage with an error")
+	}
+	return complete(ctx, ref, value, loc, opts)
+}
+
+func complete(ctx context.Context, ref StageRef, value interface{}, loc *codeLoc, opts []FlowOption) (bool, error) {
+	if ref.FlowID == "" || ref.StageID == "" {
+		return false, fmt.Errorf("Invalid stage reference %q", ref)
+	}
+	options := &flowOptions{contentType: GobMediaHeader}
+	for _, opt := range opts {
+		opt(options)
+	}
+	client, err := newFlowClient(ctx, options)
+	if err != nil {
+		return false, err
+	}
+	return client.complete(ctx, ref.FlowID, ref.StageID, value, loc)
+}
@@ -0,0 +1,59 @@
+package flow
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/fnproject/flow-lib-go/blobstore"
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+func TestStageRefString(t *testing.T) {
+	ref := StageRef{FlowID: "app/flow-1", StageID: "3"}
+	parsed, err := ParseStageRef(ref.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != ref {
+		t.Errorf("got %+v, want %+v", parsed, ref)
+	}
+	for _, s := range []string{"", "flow", "/3", "flow/"} {
+		if _, err := ParseStageRef(s); err == nil {
+			t.Errorf("got no error parsing %q", s)
+		}
+	}
+}
+
+func TestStageRefJSON(t *testing.T) {
+	ref := StageRef{FlowID: "flow", StageID: "3"}
+	data, err := json.Marshal(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"flow_id":"flow","stage_id":"3"}` {
+		t.Errorf("got %s", data)
+	}
+}
+
+func TestUntypedResultOfBlob(t *testing.T) {
+	store := blobstore.NewMemoryBlobStore()
+	result, err := valueToModel("approved", "flow", store, JSONMediaHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &flow{flowID: "flow"}
+
+	// e.g. an untyped EmptyFuture completed externally
+	if v, err := decodeResult(result, f, nil, store); err != nil || v != nil {
+		t.Errorf("got %v %v, want nil for a value without a type", v, err)
+	}
+	if v, err := decodeResult(result, f, reflect.TypeOf(""), store); err != nil || v != "approved" {
+		t.Errorf("got %v %v, want approved", v, err)
+	}
+
+	empty := &models.ModelCompletionResult{Successful: true, Datum: &models.ModelDatum{Empty: map[string]interface{}{}}}
+	if v, err := decodeResult(empty, f, nil, store); err != nil || v != nil {
+		t.Errorf("got %v %v, want nil for an empty result", v, err)
+	}
+}
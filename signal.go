@@ -0,0 +1,106 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+// ErrNoSignal is returned by SendSignal if the flow hasn't asked for the
+// signal with Signal
+var ErrNoSignal = errors.New("No such signal")
+
+var errEmptySignalName = errors.New("Signal name must not be empty")
+
+// Signal returns the externalCompletion stage waiting for the named signal,
+// adding it to the flow if this is the first time the flow asks for it
+func (cf *flow) Signal(ctx context.Context, name string) FlowFuture {
+	sid, err := cf.signal(ctx, name, newCodeLoc())
+	return &flowFuture{flow: cf, stageID: sid, err: err}
+}
+
+// Signal is the typed equivalent of Flow.Signal
+func Signal[T any](ctx context.Context, fl Flow, name string) Future[T] {
+	cf := asFlow(fl)
+	sid, err := cf.signal(ctx, name, newCodeLoc())
+	return newFuture[T](cf, sid, err)
+}
+
+func (cf *flow) signal(ctx context.Context, name string, loc *codeLoc) (string, error) {
+	if name == "" {
+		return "", errEmptySignalName
+	}
+	stages, err := signalStages(ctx, cf.client, cf.flowID, name)
+	if err != nil {
+		return "", err
+	}
+	if len(stages) > 0 {
+		return stages[0], nil
+	}
+	return cf.client.addSignal(cf.flowID, name, loc)
+}
+
+// SendSignal completes the stages of a flow waiting for the named signal
+// with a value, which may be an error to fail them, using the flow service
+// at COMPLETER_BASE_URL. It returns false if they were already complete,
+// and an error wrapping ErrNoSignal if the flow hasn't asked for the signal
+// yet. Options select the codec of the value, as for WithFlow.
+func SendSignal(flowID string, name string, value interface{}, opts ...FlowOption) (bool, error) {
+	return sendSignal(context.Background(), flowID, name, value, newCodeLoc(), opts...)
+}
+
+// SendSignalContext is SendSignal with a context that cancels looking up
+// and completing the signal's stages
+func SendSignalContext(ctx context.Context, flowID string, name string, value interface{}, opts ...FlowOption) (bool, error) {
+	return sendSignal(ctx, flowID, name, value, newCodeLoc(), opts...)
+}
+
+func sendSignal(ctx context.Context, flowID string, name string, value interface{}, loc *codeLoc, opts ...FlowOption) (bool, error) {
+	if name == "" {
+		return false, errEmptySignalName
+	}
+	options := &flowOptions{contentType: GobMediaHeader}
+	for _, opt := range opts {
+		opt(options)
+	}
+	client, err := newFlowClient(ctx, options)
+	if err != nil {
+		return false, err
+	}
+
+	stages, err := signalStages(ctx, client, flowID, name)
+	if err != nil {
+		return false, err
+	}
+	if len(stages) == 0 {
+		return false, fmt.Errorf("%w %q in flow %s", ErrNoSignal, name, flowID)
+	}
+	// flows racing to add the signal's stage may have added more than one
+	var completed bool
+	for _, sid := range stages {
+		ok, err := client.complete(ctx, flowID, sid, value, loc)
+		if err != nil {
+			return completed, err
+		}
+		completed = completed || ok
+	}
+	return completed, nil
+}
+
+// signalStages returns the IDs of the stages of a flow waiting for the
+// named signal, in the order they were added
+func signalStages(ctx context.Context, client flowClient, flowID string, name string) ([]string, error) {
+	g, err := client.state(ctx, flowID)
+	if err != nil {
+		return nil, err
+	}
+	var stages []string
+	for _, s := range g.Stages {
+		if s.Operation == models.ModelCompletionOperationExternalCompletion && s.Signal == name {
+			stages = append(stages, s.ID)
+		}
+	}
+	return stages, nil
+}
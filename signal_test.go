@@ -0,0 +1,134 @@
+package flow_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	fdk "github.com/fnproject/fdk-go"
+	flow "github.com/fnproject/flow-lib-go"
+	"github.com/fnproject/flow-lib-go/flowtest"
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+// signalResults receives the values of the signals of the flows under test
+var signalResults = make(chan string, 10)
+
+func recordSignal(s string) {
+	signalResults <- s
+}
+
+func init() {
+	if err := flow.RegisterAction(recordSignal); err != nil {
+		panic(err)
+	}
+}
+
+// signalHarness returns a harness whose flows wait for the "approved"
+// signal, asking for it twice, after adding a completed value stage that
+// isn't a signal
+func signalHarness(refs chan<- [2]flow.StageRef) *flowtest.Harness {
+	return flowtest.New(flow.WithFlow(fdk.HandlerFunc(func(ctx context.Context, in io.Reader, out io.Writer) {
+		fl := flow.FromContext(ctx)
+		flow.CompletedValue(fl, "not a signal")
+		first := flow.Signal[string](ctx, fl, "approved")
+		second := flow.Signal[string](ctx, fl, "approved")
+		flow.ThenAccept(second, recordSignal)
+		refs <- [2]flow.StageRef{first.Ref(), second.Ref()}
+	})))
+}
+
+func TestSignal(t *testing.T) {
+	refs := make(chan [2]flow.StageRef, 1)
+	h := signalHarness(refs)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	inv := h.Invoke(ctx, strings.NewReader(""))
+
+	r := <-refs
+	if r[0].StageID == "" || r[0] != r[1] {
+		t.Fatalf("got stages %v and %v, want the same stage for the same signal", r[0], r[1])
+	}
+	g, err := flow.InspectContext(h.Context(ctx), inv.FlowID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Stages) != 3 {
+		t.Errorf("got %d stages, want the value, the signal's stage and its dependent only", len(g.Stages))
+	}
+	if s := g.Stage(r[0].StageID); s == nil || s.Operation != models.ModelCompletionOperationExternalCompletion || s.Signal != "approved" {
+		t.Errorf("got stage %+v, want an externalCompletion stage waiting for the signal", s)
+	}
+
+	ok, err := flow.SendSignalContext(h.Context(ctx), inv.FlowID, "approved", "yes")
+	if err != nil || !ok {
+		t.Fatalf("got %v %v sending the signal, want true", ok, err)
+	}
+	if err := inv.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-signalResults:
+		if s != "yes" {
+			t.Errorf("got %q, want %q", s, "yes")
+		}
+	default:
+		t.Fatal("the signal's future wasn't completed")
+	}
+
+	ok, err = flow.SendSignalContext(h.Context(ctx), inv.FlowID, "approved", "again")
+	if err != nil || ok {
+		t.Errorf("got %v %v sending the signal again, want false", ok, err)
+	}
+}
+
+func TestSendSignalErrors(t *testing.T) {
+	refs := make(chan [2]flow.StageRef, 1)
+	h := signalHarness(refs)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	inv := h.Invoke(ctx, strings.NewReader(""))
+	<-refs
+
+	if _, err := flow.SendSignalContext(h.Context(ctx), inv.FlowID, "rejected", "no"); !errors.Is(err, flow.ErrNoSignal) {
+		t.Errorf("got %v sending an unknown signal, want ErrNoSignal", err)
+	}
+	if _, err := flow.SendSignalContext(h.Context(ctx), inv.FlowID, "", "no"); err == nil {
+		t.Error("got no error sending a signal without a name")
+	}
+	cancelled, cancelLookup := context.WithCancel(h.Context(ctx))
+	cancelLookup()
+	if _, err := flow.SendSignalContext(cancelled, inv.FlowID, "approved", "no"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v sending a signal with a cancelled context, want the context's error", err)
+	}
+
+	// release the flow
+	if _, err := flow.SendSignalContext(h.Context(ctx), inv.FlowID, "approved", "yes"); err != nil {
+		t.Fatal(err)
+	}
+	if err := inv.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-signalResults
+}
+
+func TestSignalCancelled(t *testing.T) {
+	errs := make(chan error, 1)
+	h := flowtest.New(flow.WithFlow(fdk.HandlerFunc(func(ctx context.Context, in io.Reader, out io.Writer) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := flow.Signal[string](cancelled, flow.FromContext(ctx), "approved").Await(ctx)
+		errs <- err
+	})))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := h.Invoke(ctx, strings.NewReader("")).Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want the context's error", err)
+	}
+}
@@ -0,0 +1,119 @@
+package flow
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+// StageStatus is the status of a stage as reported by the flow service
+type StageStatus string
+
+const (
+	StagePending    StageStatus = "pending"    // waiting for its dependencies or completion
+	StageRunning    StageStatus = "running"    // its action or function is being invoked
+	StageSuccessful StageStatus = "successful" // completed with a value
+	StageFailed     StageStatus = "failed"     // completed with an error
+)
+
+// Complete reports whether the stage has a result
+func (s StageStatus) Complete() bool {
+	return s == StageSuccessful || s == StageFailed
+}
+
+// StageState is a stage of a flow graph
+type StageState struct {
+	ID           string
+	Operation    models.ModelCompletionOperation
+	Status       StageStatus
+	Dependencies []string // IDs of the stages this stage depends on
+	CodeLocation string   // where the stage was added, only known for states replayed from events
+	Signal       string   // the signal an externalCompletion stage waits for, if any
+}
+
+// GraphState is a snapshot of the stages of a flow
+type GraphState struct {
+	FlowID     string
+	FunctionID string
+	Stages     []*StageState // in the order they were added
+}
+
+// Stage returns the stage with the given ID, or nil if there is none
+func (g *GraphState) Stage(stageID string) *StageState {
+	for _, s := range g.Stages {
+		if s.ID == stageID {
+			return s
+		}
+	}
+	return nil
+}
+
+// Incomplete returns the stages without a result, i.e. those a flow that
+// hasn't terminated is waiting on
+func (g *GraphState) Incomplete() []*StageState {
+	var stages []*StageState
+	for _, s := range g.Stages {
+		if !s.Status.Complete() {
+			stages = append(stages, s)
+		}
+	}
+	return stages
+}
+
+// Dependents returns the stages that depend on the given stage
+func (g *GraphState) Dependents(stageID string) []*StageState {
+	var stages []*StageState
+	for _, s := range g.Stages {
+		for _, dep := range s.Dependencies {
+			if dep == stageID {
+				stages = append(stages, s)
+				break
+			}
+		}
+	}
+	return stages
+}
+
+// Inspect returns the state of any flow, using the flow service at
+// COMPLETER_BASE_URL
+func Inspect(flowID string) (*GraphState, error) {
+	return InspectContext(context.Background(), flowID)
+}
+
+// InspectContext is Inspect with a context that cancels the request
+func InspectContext(ctx context.Context, flowID string) (*GraphState, error) {
+	client, err := newFlowClient(ctx, &flowOptions{contentType: GobMediaHeader})
+	if err != nil {
+		return nil, err
+	}
+	return client.state(ctx, flowID)
+}
+
+func (cf *flow) State(ctx context.Context) (*GraphState, error) {
+	return cf.client.state(ctx, cf.flowID)
+}
+
+func stateFromModel(m *models.ModelGetGraphStateResponse) *GraphState {
+	g := &GraphState{FlowID: m.FlowID, FunctionID: m.FunctionID}
+	for id, s := range m.Stages {
+		g.Stages = append(g.Stages, &StageState{
+			ID:           id,
+			Operation:    models.ModelCompletionOperation(s.Type),
+			Status:       StageStatus(s.Status),
+			Dependencies: s.Dependencies,
+			Signal:       s.Signal,
+		})
+	}
+	// stage IDs are sequence numbers, but the service returns them as a map
+	sort.Slice(g.Stages, func(i, j int) bool {
+		a, errA := strconv.Atoi(g.Stages[i].ID)
+		b, errB := strconv.Atoi(g.Stages[j].ID)
+		if errA != nil || errB != nil {
+			return g.Stages[i].ID < g.Stages[j].ID
+		}
+		return a < b
+	})
+	return g
+}
@@ -0,0 +1,57 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInspectContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/flows/flow" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", JSONMediaHeader)
+		fmt.Fprint(w, `{"flow_id":"flow","function_id":"app/fn","stages":{
+			"1":{"type":"thenApply","status":"pending","dependencies":["0"]},
+			"0":{"type":"supply","status":"successful"}}}`)
+	}))
+	defer srv.Close()
+	t.Setenv("COMPLETER_BASE_URL", srv.URL)
+
+	g, err := InspectContext(context.Background(), "flow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.FlowID != "flow" || g.FunctionID != "app/fn" || len(g.Stages) != 2 {
+		t.Fatalf("got %+v", g)
+	}
+	if g.Stages[0].ID != "0" || g.Stages[1].ID != "1" {
+		t.Errorf("got stages %s and %s, want them in order", g.Stages[0].ID, g.Stages[1].ID)
+	}
+	if incomplete := g.Incomplete(); len(incomplete) != 1 || incomplete[0].ID != "1" {
+		t.Errorf("got incomplete stages %v, want stage 1", incomplete)
+	}
+}
+
+func TestInspectContextCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	t.Setenv("COMPLETER_BASE_URL", srv.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := InspectContext(ctx, "flow"); err == nil {
+		t.Error("got no error inspecting with a cancelled context")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("took %v to return after the context was done", d)
+	}
+}
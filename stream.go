@@ -0,0 +1,186 @@
+package flow
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+const (
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+)
+
+// WatchFlow delivers the events of a flow as they happen, starting after
+// the event with sequence number fromSeq, or from the first event if zero.
+// If the stream drops it is reconnected, resuming after the last event
+// delivered; events that can't be decoded are skipped. The channel is
+// closed after the flow's GraphCompleted event, when ctx is done, or when
+// reconnecting fails with a non-retryable error.
+func WatchFlow(ctx context.Context, flowID string, fromSeq uint64) (<-chan *models.ModelGraphEvent, error) {
+	base, err := CompleterURL()
+	if err != nil {
+		return nil, err
+	}
+	return watchFlow(ctx, base.String(), flowID, fromSeq)
+}
+
+func watchFlow(ctx context.Context, base string, flowID string, fromSeq uint64) (<-chan *models.ModelGraphEvent, error) {
+	streamURL := func() string {
+		return flowStreamURL(base, flowID, fromSeq)
+	}
+	return watch(ctx, "stream flow events", streamURL, func(ev *models.ModelGraphEvent) bool {
+		fromSeq = ev.Seq
+		return ev.GraphCompleted == nil
+	})
+}
+
+func flowStreamURL(base string, flowID string, fromSeq uint64) string {
+	return fmt.Sprintf("%s/v1/flows/%s/stream?from_seq=%d", strings.TrimSuffix(base, "/"), url.PathEscape(flowID), fromSeq)
+}
+
+// watch follows a stream of newline-delimited JSON events, reconnecting
+// until next returns false for a delivered event or ctx is done. streamURL
+// is called on each connection so it can resume after the events seen.
+// Only failures to read the stream reconnect it; events that can't be
+// decoded are skipped.
+func watch[T any](ctx context.Context, op string, streamURL func() string, next func(T) bool) (<-chan T, error) {
+	hc := streamHTTPClient()
+	body, err := openStream(ctx, hc, op, streamURL())
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+		delay := minReconnectDelay
+		for {
+			r := bufio.NewReader(body)
+			for {
+				line, err := readStreamLine(r)
+				if err != nil {
+					if err != io.EOF && ctx.Err() == nil {
+						debug(fmt.Sprintf("Failed to %s: %v", op, err))
+					}
+					break
+				}
+				ev, err := decodeStreamEvent[T](line)
+				if err != nil {
+					debug(fmt.Sprintf("Skipping event of %s: %v", op, err))
+					continue
+				}
+				delay = minReconnectDelay
+				select {
+				case ch <- ev:
+				case <-ctx.Done():
+					body.Close()
+					return
+				}
+				if !next(ev) {
+					body.Close()
+					return
+				}
+			}
+			body.Close()
+
+			for {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+				if delay *= 2; delay > maxReconnectDelay {
+					delay = maxReconnectDelay
+				}
+				if body, err = openStream(ctx, hc, op, streamURL()); err == nil {
+					break
+				}
+				debug(fmt.Sprintf("Failed to reconnect to %s: %v", op, err))
+				var clientErr *ClientError
+				if errors.As(err, &clientErr) && !clientErr.Retryable() {
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func openStream(ctx context.Context, hc *http.Client, op string, streamURL string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, streamURL, nil)
+	if err != nil {
+		return nil, &ClientError{Op: op, Err: err}
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", JSONMediaHeader)
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, newClientError(op, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, &ClientError{Op: op, StatusCode: resp.StatusCode, Err: fmt.Errorf("%s", msg)}
+	}
+	return resp.Body, nil
+}
+
+// readStreamLine returns the next non-blank line of a stream, or the error
+// that ended it. A partial line is discarded if the stream fails mid-line.
+func readStreamLine(r *bufio.Reader) ([]byte, error) {
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// decodeStreamEvent decodes an event from a line of a stream, which the
+// flow service wraps in a result envelope
+func decodeStreamEvent[T any](line []byte) (T, error) {
+	var ev T
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(line, &envelope); err != nil {
+		return ev, err
+	}
+	if envelope.Error != nil {
+		return ev, fmt.Errorf("Stream reported an error: %s", envelope.Error)
+	}
+	raw := json.RawMessage(line)
+	if envelope.Result != nil {
+		raw = envelope.Result
+	}
+	err := json.Unmarshal(raw, &ev)
+	return ev, err
+}
+
+// streamHTTPClient returns a client without a timeout for long-lived
+// streams, sharing the transport of any client set with UseHTTPClient
+func streamHTTPClient() *http.Client {
+	if httpClient != nil {
+		return &http.Client{Transport: httpClient.Transport}
+	}
+	return &http.Client{}
+}
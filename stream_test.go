@@ -0,0 +1,138 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fnproject/flow-lib-go/models"
+)
+
+// streamServer serves the event stream of flow "flow", calling serve with
+// the from_seq of each connection
+func streamServer(t *testing.T, serve func(w http.ResponseWriter, conn int, fromSeq string)) *httptest.Server {
+	var mu sync.Mutex
+	var conns int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/flows/flow/stream" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		conns++
+		conn := conns
+		mu.Unlock()
+		serve(w, conn, r.URL.Query().Get("from_seq"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func eventLine(seq int, completed bool) string {
+	if completed {
+		return fmt.Sprintf(`{"result":{"seq":%d,"flow_id":"flow","graph_completed":{"flow_id":"flow"}}}`+"\n", seq)
+	}
+	return fmt.Sprintf(`{"result":{"seq":%d,"flow_id":"flow","graph_committed":{"flow_id":"flow"}}}`+"\n", seq)
+}
+
+func collect(t *testing.T, evs <-chan *models.ModelGraphEvent) []uint64 {
+	t.Helper()
+	var seqs []uint64
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case ev, ok := <-evs:
+			if !ok {
+				return seqs
+			}
+			seqs = append(seqs, ev.Seq)
+		case <-timeout:
+			t.Fatalf("stream wasn't closed, got events %v", seqs)
+		}
+	}
+}
+
+func TestWatchFlowSkipsUndecodableEvents(t *testing.T) {
+	srv := streamServer(t, func(w http.ResponseWriter, conn int, fromSeq string) {
+		if conn > 1 {
+			t.Errorf("reconnected from %s after an undecodable event", fromSeq)
+			http.Error(w, "reconnected", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, eventLine(1, false))
+		fmt.Fprint(w, "not json\n")
+		fmt.Fprint(w, `{"result":{"seq":"two"}}`+"\n")
+		fmt.Fprint(w, `{"error":{"message":"boom"}}`+"\n")
+		fmt.Fprint(w, "\n")
+		fmt.Fprint(w, eventLine(2, false))
+		fmt.Fprint(w, eventLine(3, true))
+	})
+
+	evs, err := watchFlow(context.Background(), srv.URL, "flow", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(t, evs); fmt.Sprint(got) != "[1 2 3]" {
+		t.Errorf("got events %v, want [1 2 3]", got)
+	}
+}
+
+func TestWatchFlowReconnectsAfterStreamEnds(t *testing.T) {
+	srv := streamServer(t, func(w http.ResponseWriter, conn int, fromSeq string) {
+		switch conn {
+		case 1:
+			if fromSeq != "0" {
+				t.Errorf("connected from %s, want 0", fromSeq)
+			}
+			fmt.Fprint(w, eventLine(1, false))
+			fmt.Fprint(w, eventLine(2, false))
+		default:
+			if fromSeq != "2" {
+				t.Errorf("reconnected from %s, want 2", fromSeq)
+			}
+			fmt.Fprint(w, eventLine(3, false))
+			// the last event isn't followed by a newline
+			fmt.Fprint(w, eventLine(4, true)[:len(eventLine(4, true))-1])
+		}
+	})
+
+	evs, err := watchFlow(context.Background(), srv.URL, "flow", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(t, evs); fmt.Sprint(got) != "[1 2 3 4]" {
+		t.Errorf("got events %v, want [1 2 3 4]", got)
+	}
+}
+
+func TestWatchFlowStopsOnNonRetryableError(t *testing.T) {
+	srv := streamServer(t, func(w http.ResponseWriter, conn int, fromSeq string) {
+		if conn == 1 {
+			fmt.Fprint(w, eventLine(1, false))
+			return
+		}
+		http.Error(w, "flow not found", http.StatusNotFound)
+	})
+
+	evs, err := watchFlow(context.Background(), srv.URL, "flow", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(t, evs); fmt.Sprint(got) != "[1]" {
+		t.Errorf("got events %v, want [1]", got)
+	}
+}
+
+func TestWatchFlowOpenError(t *testing.T) {
+	srv := streamServer(t, func(w http.ResponseWriter, conn int, fromSeq string) {
+		http.Error(w, "flow not found", http.StatusNotFound)
+	})
+	_, err := watchFlow(context.Background(), srv.URL, "flow", 0)
+	if clientErr, ok := err.(*ClientError); !ok || clientErr.StatusCode != http.StatusNotFound {
+		t.Errorf("got %v, want a ClientError with status 404", err)
+	}
+}
@@ -0,0 +1,306 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// Future is a statically typed view of a FlowFuture whose successful
+// result is of type T. Typed futures share the underlying stage with the
+// untyped API, so both can be mixed freely within the same flow.
+type Future[T any] struct {
+	f *flowFuture
+}
+
+// typedFuture is implemented by all instantiations of Future so that
+// values returned from composing actions can be published as stage refs
+type typedFuture interface {
+	untyped() *flowFuture
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func asFlow(fl Flow) *flow {
+	cf, ok := fl.(*flow)
+	if !ok {
+		panic("Third-party implementations of Flow are not supported!")
+	}
+	return cf
+}
+
+func asFlowFuture(ff FlowFuture) *flowFuture {
+	f, ok := ff.(*flowFuture)
+	if !ok {
+		panic("Third-party implementations of FlowFuture are not supported!")
+	}
+	return f
+}
+
+func newFuture[T any](cf *flow, stageID string, err error) Future[T] {
+	return Future[T]{f: &flowFuture{flow: cf, stageID: stageID, returnType: typeOf[T](), err: err}}
+}
+
+func failedTyped[T any](f *flowFuture) Future[T] {
+	return newFuture[T](f.flow, f.stageID, f.err)
+}
+
+// Typed wraps an untyped FlowFuture so its result is decoded as T
+func Typed[T any](ff FlowFuture) Future[T] {
+	f := asFlowFuture(ff)
+	return newFuture[T](f.flow, f.stageID, f.err)
+}
+
+// Untyped returns the FlowFuture backing this future
+func (f Future[T]) Untyped() FlowFuture {
+	return f.f
+}
+
+func (f Future[T]) untyped() *flowFuture {
+	return f.f
+}
+
+// Await blocks until the stage completes or the context is done
+func (f Future[T]) Await(ctx context.Context) (T, error) {
+	var zero T
+	v, err := f.f.Await(ctx)
+	if err != nil || v == nil {
+		return zero, err
+	}
+	t, ok := v.(T)
+	if !ok {
+		return zero, fmt.Errorf("Stage result of type %v is not assignable to %v", reflect.TypeOf(v), typeOf[T]())
+	}
+	return t, nil
+}
+
+// GetWithTimeout is equivalent to Await with a context bounded by d
+func (f Future[T]) GetWithTimeout(d time.Duration) (T, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return f.Await(ctx)
+}
+
+// Err returns the error that prevented this future's stage from being
+// added to the flow, if any
+func (f Future[T]) Err() error {
+	return f.f.err
+}
+
+// Complete completes an empty future with the given value
+func (f Future[T]) Complete(value T) bool {
+	return f.f.Complete(value)
+}
+
+// Ref returns a reference to the future's stage, see FlowFuture.Ref
+func (f Future[T]) Ref() StageRef {
+	return f.f.Ref()
+}
+
+// CompletedValue returns a future already completed with the given value
+func CompletedValue[T any](fl Flow, value T) Future[T] {
+	cf := asFlow(fl)
+	sid, err := cf.client.completedValue(cf.flowID, value, newCodeLoc())
+	return newFuture[T](cf, sid, err)
+}
+
+// EmptyFuture returns a future that is completed by calling Complete
+func EmptyFuture[T any](fl Flow) Future[T] {
+	cf := asFlow(fl)
+	sid, err := cf.client.emptyFuture(cf.flowID, newCodeLoc())
+	return newFuture[T](cf, sid, err)
+}
+
+// Supply runs the action asynchronously within the flow
+func Supply[R any](fl Flow, action func() R) Future[R] {
+	return supply[R](fl, action, newCodeLoc())
+}
+
+// SupplyContext is Supply for actions taking the invocation's context, from
+// which FromContext returns the flow
+func SupplyContext[R any](fl Flow, action func(context.Context) R) Future[R] {
+	return supply[R](fl, action, newCodeLoc())
+}
+
+func supply[R any](fl Flow, action interface{}, loc *codeLoc) Future[R] {
+	cf := asFlow(fl)
+	sid, err := cf.client.supply(cf.flowID, action, loc)
+	return newFuture[R](cf, sid, err)
+}
+
+// ThenApply applies the action to the successful result of f
+func ThenApply[T, R any](f Future[T], action func(T) R) Future[R] {
+	return thenApply[T, R](f, action, newCodeLoc())
+}
+
+// ThenApplyContext is ThenApply for actions taking the invocation's context
+func ThenApplyContext[T, R any](f Future[T], action func(context.Context, T) R) Future[R] {
+	return thenApply[T, R](f, action, newCodeLoc())
+}
+
+func thenApply[T, R any](f Future[T], action interface{}, loc *codeLoc) Future[R] {
+	if ff := failedFuture(f.f); ff != nil {
+		return failedTyped[R](ff)
+	}
+	sid, err := f.f.client.thenApply(f.f.flowID, f.f.stageID, action, loc)
+	return newFuture[R](f.f.flow, sid, err)
+}
+
+// ThenCompose applies the action to the successful result of f and
+// completes with the result of the future returned by the action
+func ThenCompose[T, R any](f Future[T], action func(T) Future[R]) Future[R] {
+	return thenCompose[T, R](f, action, newCodeLoc())
+}
+
+// ThenComposeContext is ThenCompose for actions taking the invocation's
+// context, typically to create the returned future in the flow
+func ThenComposeContext[T, R any](f Future[T], action func(context.Context, T) Future[R]) Future[R] {
+	return thenCompose[T, R](f, action, newCodeLoc())
+}
+
+func thenCompose[T, R any](f Future[T], action interface{}, loc *codeLoc) Future[R] {
+	if ff := failedFuture(f.f); ff != nil {
+		return failedTyped[R](ff)
+	}
+	sid, err := f.f.client.thenCompose(f.f.flowID, f.f.stageID, action, loc)
+	return newFuture[R](f.f.flow, sid, err)
+}
+
+// ThenCombine applies the action to the successful results of f and other
+func ThenCombine[T, U, R any](f Future[T], other Future[U], action func(T, U) R) Future[R] {
+	return thenCombine[T, U, R](f, other, action, newCodeLoc())
+}
+
+// ThenCombineContext is ThenCombine for actions taking the invocation's
+// context
+func ThenCombineContext[T, U, R any](f Future[T], other Future[U], action func(context.Context, T, U) R) Future[R] {
+	return thenCombine[T, U, R](f, other, action, newCodeLoc())
+}
+
+func thenCombine[T, U, R any](f Future[T], other Future[U], action interface{}, loc *codeLoc) Future[R] {
+	if ff := failedFuture(f.f, other.f); ff != nil {
+		return failedTyped[R](ff)
+	}
+	sid, err := f.f.client.thenCombine(f.f.flowID, f.f.stageID, other.f.stageID, action, loc)
+	return newFuture[R](f.f.flow, sid, err)
+}
+
+// ThenAccept consumes the successful result of f
+func ThenAccept[T any](f Future[T], action func(T)) Future[struct{}] {
+	return thenAccept[T](f, action, newCodeLoc())
+}
+
+// ThenAcceptContext is ThenAccept for actions taking the invocation's
+// context
+func ThenAcceptContext[T any](f Future[T], action func(context.Context, T)) Future[struct{}] {
+	return thenAccept[T](f, action, newCodeLoc())
+}
+
+func thenAccept[T any](f Future[T], action interface{}, loc *codeLoc) Future[struct{}] {
+	if ff := failedFuture(f.f); ff != nil {
+		return failedTyped[struct{}](ff)
+	}
+	sid, err := f.f.client.thenAccept(f.f.flowID, f.f.stageID, action, loc)
+	return newFuture[struct{}](f.f.flow, sid, err)
+}
+
+// Handle applies the action to either the result or the error of f
+func Handle[T, R any](f Future[T], action func(T, error) R) Future[R] {
+	return handle[T, R](f, action, newCodeLoc())
+}
+
+// HandleContext is Handle for actions taking the invocation's context
+func HandleContext[T, R any](f Future[T], action func(context.Context, T, error) R) Future[R] {
+	return handle[T, R](f, action, newCodeLoc())
+}
+
+func handle[T, R any](f Future[T], action interface{}, loc *codeLoc) Future[R] {
+	if ff := failedFuture(f.f); ff != nil {
+		return failedTyped[R](ff)
+	}
+	sid, err := f.f.client.handle(f.f.flowID, f.f.stageID, action, loc)
+	return newFuture[R](f.f.flow, sid, err)
+}
+
+// Exceptionally recovers from a failure of f with the result of the action
+func Exceptionally[T any](f Future[T], action func(error) T) Future[T] {
+	return exceptionally[T](f, action, newCodeLoc())
+}
+
+// ExceptionallyContext is Exceptionally for actions taking the
+// invocation's context
+func ExceptionallyContext[T any](f Future[T], action func(context.Context, error) T) Future[T] {
+	return exceptionally[T](f, action, newCodeLoc())
+}
+
+func exceptionally[T any](f Future[T], action interface{}, loc *codeLoc) Future[T] {
+	if ff := failedFuture(f.f); ff != nil {
+		return failedTyped[T](ff)
+	}
+	sid, err := f.f.client.exceptionally(f.f.flowID, f.f.stageID, action, loc)
+	return newFuture[T](f.f.flow, sid, err)
+}
+
+// ExceptionallyCompose recovers from a failure of f with the result of
+// the future returned by the action
+func ExceptionallyCompose[T any](f Future[T], action func(error) Future[T]) Future[T] {
+	return exceptionallyCompose[T](f, action, newCodeLoc())
+}
+
+// ExceptionallyComposeContext is ExceptionallyCompose for actions taking
+// the invocation's context, typically to create the returned future in
+// the flow
+func ExceptionallyComposeContext[T any](f Future[T], action func(context.Context, error) Future[T]) Future[T] {
+	return exceptionallyCompose[T](f, action, newCodeLoc())
+}
+
+func exceptionallyCompose[T any](f Future[T], action interface{}, loc *codeLoc) Future[T] {
+	if ff := failedFuture(f.f); ff != nil {
+		return failedTyped[T](ff)
+	}
+	sid, err := f.f.client.exceptionallyCompose(f.f.flowID, f.f.stageID, action, loc)
+	return newFuture[T](f.f.flow, sid, err)
+}
+
+// InvokeOption configures the request made by InvokeFunctionJSON
+type InvokeOption func(*HTTPRequest)
+
+// WithMethod sets the HTTP method of the request, POST by default
+func WithMethod(method string) InvokeOption {
+	return func(req *HTTPRequest) {
+		req.Method = method
+	}
+}
+
+// WithHeader adds a header to the request
+func WithHeader(key, value string) InvokeOption {
+	return func(req *HTTPRequest) {
+		req.Headers.Add(key, value)
+	}
+}
+
+// InvokeFunctionJSON invokes a function with the JSON encoding of in as its
+// request body, and decodes the body of the function's response into R.
+// Responses with a non-2xx status fail the future with a *FunctionError.
+func InvokeFunctionJSON[R any](fl Flow, functionID string, in interface{}, opts ...InvokeOption) Future[R] {
+	cf := asFlow(fl)
+	body, err := json.Marshal(in)
+	if err != nil {
+		return newFuture[R](cf, "", fmt.Errorf("Failed to encode request to function %s: %v", functionID, err))
+	}
+	req := &HTTPRequest{Method: http.MethodPost, Headers: make(http.Header), Body: body}
+	req.Headers.Set(ContentTypeHeader, JSONMediaHeader)
+	req.Headers.Set("Accept", JSONMediaHeader)
+	for _, opt := range opts {
+		opt(req)
+	}
+	sid, err := cf.client.invokeFunction(cf.flowID, functionID, req, newCodeLoc())
+	f := newFuture[R](cf, sid, err)
+	f.f.functionID = functionID
+	return f
+}
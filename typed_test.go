@@ -0,0 +1,99 @@
+package flow_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	fdk "github.com/fnproject/fdk-go"
+	flow "github.com/fnproject/flow-lib-go"
+	"github.com/fnproject/flow-lib-go/flowtest"
+)
+
+// typedResults receives the results of the typed flows under test
+var typedResults = make(chan string, 1)
+
+func upperInFlow(ctx context.Context, s string) string {
+	flow.FromContext(ctx) // panics if the action didn't get the flow's context
+	return strings.ToUpper(s)
+}
+
+func exclaimInFlow(ctx context.Context, s string) flow.Future[string] {
+	return flow.CompletedValue(flow.FromContext(ctx), s+"!")
+}
+
+func failInFlow(ctx context.Context, s string) string {
+	panic("failed " + s)
+}
+
+func recoverInFlow(ctx context.Context, err error) string {
+	flow.FromContext(ctx)
+	return "recovered"
+}
+
+func handleInFlow(ctx context.Context, s string, err error) string {
+	flow.FromContext(ctx)
+	return s + " handled"
+}
+
+func recordTypedResult(ctx context.Context, s string) {
+	flow.FromContext(ctx)
+	typedResults <- s
+}
+
+func init() {
+	for _, action := range []interface{}{upperInFlow, exclaimInFlow, failInFlow, recoverInFlow, handleInFlow, recordTypedResult} {
+		if err := flow.RegisterAction(action); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func runTyped(t *testing.T, build func(fl flow.Flow) flow.Future[string]) string {
+	h := flowtest.New(flow.WithFlow(fdk.HandlerFunc(func(ctx context.Context, in io.Reader, out io.Writer) {
+		flow.ThenAcceptContext(build(flow.FromContext(ctx)), recordTypedResult)
+	})))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := h.Invoke(ctx, strings.NewReader("")).Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-typedResults:
+		return s
+	default:
+		t.Fatal("flow completed without a result")
+		return ""
+	}
+}
+
+func TestTypedContextActions(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(fl flow.Flow) flow.Future[string]
+		want  string
+	}{
+		{"ThenApplyContext", func(fl flow.Flow) flow.Future[string] {
+			return flow.ThenApplyContext(flow.CompletedValue(fl, "hello"), upperInFlow)
+		}, "HELLO"},
+		{"ThenComposeContext", func(fl flow.Flow) flow.Future[string] {
+			return flow.ThenComposeContext(flow.CompletedValue(fl, "hello"), exclaimInFlow)
+		}, "hello!"},
+		{"ExceptionallyContext", func(fl flow.Flow) flow.Future[string] {
+			failed := flow.ThenApplyContext(flow.CompletedValue(fl, "hello"), failInFlow)
+			return flow.ExceptionallyContext(failed, recoverInFlow)
+		}, "recovered"},
+		{"HandleContext", func(fl flow.Flow) flow.Future[string] {
+			return flow.HandleContext(flow.CompletedValue(fl, "hello"), handleInFlow)
+		}, "hello handled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runTyped(t, tt.build); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
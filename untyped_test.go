@@ -0,0 +1,76 @@
+package flow_test
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	fdk "github.com/fnproject/fdk-go"
+	flow "github.com/fnproject/flow-lib-go"
+	"github.com/fnproject/flow-lib-go/flowtest"
+)
+
+func composeValue(ctx context.Context, s string) flow.FlowFuture {
+	return flow.FromContext(ctx).CompletedValue(s + "!")
+}
+
+func init() {
+	if err := flow.RegisterAction(composeValue); err != nil {
+		panic(err)
+	}
+}
+
+// TestUntypedGet checks that untyped futures without a type get nil for
+// their values rather than failing
+func TestUntypedGet(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(fl flow.Flow) flow.FlowFuture
+		typed string // the value got with GetType, if any
+	}{
+		{"ThenCompose", func(fl flow.Flow) flow.FlowFuture {
+			return fl.CompletedValue("hello").ThenCompose(composeValue)
+		}, "hello!"},
+		{"EmptyFuture", func(fl flow.Flow) flow.FlowFuture {
+			f := fl.EmptyFuture()
+			f.Complete("hello")
+			return f
+		}, "hello"},
+		{"AnyOf", func(fl flow.Flow) flow.FlowFuture {
+			return fl.AnyOf(fl.CompletedValue("hello"), fl.CompletedValue(42))
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v interface{}
+			var err error
+			var typed interface{}
+			h := flowtest.New(flow.WithFlow(fdk.HandlerFunc(func(ctx context.Context, in io.Reader, out io.Writer) {
+				f := tt.build(flow.FromContext(ctx))
+				v, err = f.GetWithTimeout(5 * time.Second)
+				if tt.typed != "" {
+					valueCh, errCh := f.GetType(reflect.TypeOf(""))
+					select {
+					case typed = <-valueCh:
+					case typed = <-errCh:
+					}
+				}
+			})))
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := h.Invoke(ctx, strings.NewReader("")).Wait(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if err != nil || v != nil {
+				t.Errorf("got %v %v, want nil", v, err)
+			}
+			if tt.typed != "" && typed != tt.typed {
+				t.Errorf("got %v from GetType, want the value", typed)
+			}
+		})
+	}
+}